@@ -3,7 +3,9 @@ package main
 import (
 	"log"
 	"fmt"
-	
+	"os"
+	// Embed the time zone database for schedules, the distroless image has none
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 	"github.com/alustan/terraform-controller/pkg/util"
@@ -29,12 +31,35 @@ func main() {
 	syncInterval := util.GetSyncInterval()
 	log.Printf("Sync interval is set to %v", syncInterval)
 
-	ctrl := controller.NewInClusterController(syncInterval)
+	pollInterval := util.GetPollInterval()
+	log.Printf("Poll interval is set to %v", pollInterval)
+
+	ctrl := controller.NewInClusterController(syncInterval, pollInterval)
 
 	// Start the reconciliation loop in a separate goroutine
 	go ctrl.Reconcile()
 
 	r.POST("/sync", ctrl.ServeHTTP)
+	r.POST("/runs/sync", ctrl.HandleRunSync)
+	r.POST("/webhooks/github", ctrl.HandleGitHubWebhook)
+	r.POST("/webhooks/gitlab", ctrl.HandleGitLabWebhook)
+	r.POST("/webhooks/bitbucket", ctrl.HandleBitbucketWebhook)
+	r.POST("/api/v1/namespaces/:namespace/terraforms/:name/cancel", ctrl.HandleCancel)
+	r.GET("/api/v1/namespaces/:namespace/terraforms/:name/state", ctrl.HandleState)
+	r.GET("/api/v1/namespaces/:namespace/terraforms/:name/generated-config", ctrl.HandleGeneratedConfig)
+	r.POST("/webhooks/validate-delete", ctrl.HandleValidateDelete)
+
+	// Admission webhooks must be served over TLS
+	certFile := os.Getenv("WEBHOOK_TLS_CERT_FILE")
+	keyFile := os.Getenv("WEBHOOK_TLS_KEY_FILE")
+	if certFile != "" && keyFile != "" {
+		go func() {
+			log.Println("Starting TLS server on port 8443...")
+			if err := r.RunTLS(":8443", certFile, keyFile); err != nil {
+				log.Fatalf("Failed to start TLS server: %v", err)
+			}
+		}()
+	}
 
 	log.Println("Starting server on port 8080...")
 	if err := r.Run(":8080"); err != nil {
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alustan/terraform-controller/pkg/runner"
+)
+
+func main() {
+	plan := flag.String("plan", "", "saved plan applied instead of planning, relative to the working directory")
+	sensitiveOutputs := flag.String("sensitive-outputs", "", "file receiving the values of sensitive outputs for the controller")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] apply|destroy\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config := runner.ConfigFromEnv()
+	config.PlanFile = *plan
+	config.SensitiveOutputsFile = *sensitiveOutputs
+	if err := runner.Run(config, flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,25 @@
+package container
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// BackendInputs returns the run pod inputs writing the backend override into the Terraform
+// working directory and exposing the backend credentials Secret, if any, as environment variables.
+func BackendInputs(backendOverride, credentialsSecretName string) RunPodInputs {
+	inputs := RunPodInputs{
+		Setup: fmt.Sprintf(`printf '%%s' %s > "${WORKING_DIR:-.}/backend_override.tf" || exit 1`, shellQuote(backendOverride)),
+	}
+	if credentialsSecretName != "" {
+		inputs.EnvFrom = []corev1.EnvFromSource{
+			{
+				SecretRef: &corev1.SecretEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: credentialsSecretName},
+				},
+			},
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,152 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// interruptCommand sends SIGINT to Terraform so it stops gracefully and releases the state lock.
+var interruptCommand = []string{"/bin/sh", "-c", "pkill -INT -x terraform || pkill -INT -x tofu || true"}
+
+// CancelRun interrupts the active run, hook and build pods of a resource. Terraform gets
+// gracePeriod to exit after SIGINT before the pods are deleted. Run pods whose terraform container
+// is not running yet, e.g. while a pre hook runs in an init container, hold no state lock and are
+// deleted right away, like hook pods. It returns the names of the cancelled pods.
+func CancelRun(clientset *kubernetes.Clientset, config *rest.Config, name, namespace string, gracePeriod time.Duration) ([]string, error) {
+	runPods, err := activePods(clientset, namespace, fmt.Sprintf("apprun=%s", name))
+	if err != nil {
+		return nil, err
+	}
+	hookPods, err := activePods(clientset, namespace, fmt.Sprintf("apphook=%s", name))
+	if err != nil {
+		return nil, err
+	}
+	buildPods, err := activePods(clientset, namespace, fmt.Sprintf("appbuild=%s", name))
+	if err != nil {
+		return nil, err
+	}
+
+	var interrupted []string
+	for _, pod := range runPods {
+		if !containerRunning(pod, "terraform") {
+			continue
+		}
+		log.Printf("Sending SIGINT to Terraform in pod %s", pod.Name)
+		if err := execInPod(clientset, config, namespace, pod.Name, "terraform", interruptCommand); err != nil {
+			log.Printf("Failed to interrupt Terraform in pod %s: %v", pod.Name, err)
+			continue
+		}
+		interrupted = append(interrupted, pod.Name)
+	}
+	if len(interrupted) > 0 {
+		waitForPodsToExit(clientset, namespace, interrupted, gracePeriod)
+	}
+
+	var cancelled []string
+	for _, pod := range append(append(runPods, hookPods...), buildPods...) {
+		cancelled = append(cancelled, pod.Name)
+	}
+	for _, podName := range cancelled {
+		err := clientset.CoreV1().Pods(namespace).Delete(context.Background(), podName, metav1.DeleteOptions{})
+		if err != nil {
+			log.Printf("Failed to delete pod %s: %v", podName, err)
+			continue
+		}
+		log.Printf("Deleted pod %s", podName)
+	}
+
+	return cancelled, nil
+}
+
+// activePods returns the pending or running pods matching labelSelector.
+func activePods(clientset *kubernetes.Clientset, namespace, labelSelector string) ([]corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: labelSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var active []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodPending || pod.Status.Phase == corev1.PodRunning {
+			active = append(active, pod)
+		}
+	}
+	return active, nil
+}
+
+// containerRunning reports whether a container of the pod is running, so commands can be executed in it.
+func containerRunning(pod corev1.Pod, containerName string) bool {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == containerName {
+			return status.State.Running != nil
+		}
+	}
+	return false
+}
+
+// waitForPodsToExit polls until all pods terminated or the grace period elapsed.
+func waitForPodsToExit(clientset *kubernetes.Clientset, namespace string, podNames []string, gracePeriod time.Duration) {
+	deadline := time.Now().Add(gracePeriod)
+	for time.Now().Before(deadline) {
+		running := 0
+		for _, podName := range podNames {
+			pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+			if err == nil && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+				running++
+			}
+		}
+		if running == 0 {
+			return
+		}
+		time.Sleep(5 * time.Second)
+	}
+	log.Printf("Grace period of %v elapsed before Terraform exited", gracePeriod)
+}
+
+// execInPod runs command in a container of the pod and returns an error including stderr if it fails.
+func execInPod(clientset *kubernetes.Clientset, config *rest.Config, namespace, podName, containerName string, command []string) error {
+	_, err := execInPodOutput(clientset, config, namespace, podName, containerName, command)
+	return err
+}
+
+// execInPodOutput is execInPod returning the stdout of the command.
+func execInPodOutput(clientset *kubernetes.Clientset, config *rest.Config, namespace, podName, containerName string, command []string) (string, error) {
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
@@ -3,12 +3,14 @@ package container
 import (
 	"context"
 	
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 
-// CheckExistinPods checks for any existing pods with the specified label.
+// CheckExistinPods checks for any running or pending pods with the specified label.
+// Completed pods are kept for their logs and do not block new runs.
 func CheckExistingPods(clientset *kubernetes.Clientset, namespace, labelSelector string) (bool, error) {
     pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
         LabelSelector: labelSelector,
@@ -17,9 +19,11 @@ func CheckExistingPods(clientset *kubernetes.Clientset, namespace, labelSelector
         return false, err
     }
 
-    // If any pod with the specified label exists, return true
-    if len(pods.Items) > 0 {
-        return true, nil
+    // If any active pod with the specified label exists, return true
+    for _, pod := range pods.Items {
+        if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+            return true, nil
+        }
     }
 
     return false, nil
@@ -44,8 +44,19 @@ func DeleteConfigMapIfExists(clientset *kubernetes.Clientset, namespace, configM
     return nil
 }
 
-// CreateDockerfileConfigMap creates a Kubernetes ConfigMap with the provided Dockerfile content.
-func CreateDockerfileConfigMap(clientset *kubernetes.Clientset, name, namespace, additionalTools string, providerExists bool) (string, error) {
+// CreateDockerfileConfigMap creates a Kubernetes ConfigMap with the provided Dockerfile content,
+// installing the given Terraform or OpenTofu release, if not nil the given Terragrunt release and,
+// if runnerImage is set, the builtin runner copied from it.
+func CreateDockerfileConfigMap(clientset *kubernetes.Clientset, name, namespace, additionalTools string, providerExists bool, binary TerraformBinary, terragrunt *TerragruntBinary, runnerImage string) (string, error) {
+    if err := binary.Validate(); err != nil {
+        return "", err
+    }
+    if terragrunt != nil {
+        if err := terragrunt.Validate(); err != nil {
+            return "", err
+        }
+    }
+
     // Initialize Dockerfile content
     content := `
 FROM ubuntu:latest
@@ -58,17 +69,22 @@ RUN apt-get update && \
     unzip \
     jq \
     openssh-client \
+    procps \
     && rm -rf /var/lib/apt/lists/*
 
-RUN wget https://releases.hashicorp.com/terraform/1.8.1/terraform_1.8.1_linux_amd64.zip && \
-    unzip terraform_1.8.1_linux_amd64.zip -d /usr/local/bin/ && \
-    rm terraform_1.8.1_linux_amd64.zip
-
+` + binary.installStep() + `
 RUN curl -LO "https://dl.k8s.io/release/$(curl -L -s https://dl.k8s.io/release/stable.txt)/bin/linux/amd64/kubectl" && \
     install -o root -g root -m 0755 kubectl /usr/local/bin/kubectl && \
     rm kubectl
 `
 
+    if terragrunt != nil {
+        content += terragrunt.installStep()
+    }
+    if runnerImage != "" {
+        content += runnerInstallStep(runnerImage)
+    }
+
     // Include additionalTools if the provider exists
     if providerExists {
         content += additionalTools
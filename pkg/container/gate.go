@@ -0,0 +1,124 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// gateMarker is printed once the plan of a gated apply pod was printed
+	gateMarker = "----- AWAITING APPLY VERDICT -----"
+	// verdictFile is written by SendVerdict to let a gated apply pod apply its saved plan
+	verdictFile = "/tmp/controller-verdict"
+	// extendFile is written by ExtendGate to restart the wait of a gated apply pod
+	extendFile = "/tmp/controller-extend"
+	// gateTimeoutSeconds is how long a gated apply pod waits for its verdict before failing
+	gateTimeoutSeconds = "600"
+	// gatePollInterval is how often a gated apply pod is checked for its plan
+	gatePollInterval = 5 * time.Second
+)
+
+// gateScript waits for the verdict of the controller on the plan printed before. Without a
+// verdict the pod fails, and a rejected plan is discarded by deleting the pod.
+const gateScript = `echo '` + gateMarker + `'
+waited=0
+until [ -f ` + verdictFile + ` ]; do
+  if [ -f ` + extendFile + ` ]; then
+    rm -f ` + extendFile + `
+    waited=0
+  fi
+  if [ "$waited" -ge ` + gateTimeoutSeconds + ` ]; then
+    echo "no apply verdict received" >&2
+    exit 1
+  fi
+  sleep 2
+  waited=$((waited + 2))
+done
+`
+
+// savedApplyScript applies the saved plan of planScript and prints the outputs as JSON on the
+// last line, the values of sensitive outputs redacted.
+var savedApplyScript = `terraform apply -input=false controller.tfplan 1>&2 || exit 1
+outputs=$(mktemp)
+terraform output -json > "$outputs" || exit 1
+` + printOutputsScript(false)
+
+// GateCommand returns the command of gated apply pods. The pod saves and prints a plan made
+// with the given extra flags, as PlanCommand does, then waits for SendVerdict and applies
+// exactly that plan, printing the outputs on the last line.
+func (r Runner) GateCommand(flags string) []string {
+	switch {
+	case r.Terragrunt:
+		return r.terragruntCommand(terragruntPlanScript + gateScript + terragruntSavedApplyScript(r.RunAll))
+	case r.Builtin:
+		// The runner is started from the repository root like for the other operations
+		apply := builtinCommand("apply", "controller.tfplan")
+		for i, arg := range apply {
+			apply[i] = shellQuote(arg)
+		}
+		return []string{"/bin/bash", "-c", "repo_dir=$PWD\n" + planScript(flags, r.GenerateConfig) + gateScript + `cd "$repo_dir" || exit 1
+exec ` + strings.Join(apply, " ") + "\n"}
+	}
+	return []string{"/bin/bash", "-c", planScript(flags, r.GenerateConfig) + gateScript + savedApplyScript}
+}
+
+// WaitForGate waits until a gated apply pod printed its plan and returns it. The pod keeps
+// waiting for its verdict; an error is returned if it ends before.
+func WaitForGate(clientset *kubernetes.Clientset, namespace, podName string) (PlanOutput, error) {
+	for {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+		if err != nil {
+			return PlanOutput{}, err
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			if _, err := waitForPodLogs(clientset, namespace, podName, gatePollInterval); err != nil {
+				return PlanOutput{}, err
+			}
+			return PlanOutput{}, fmt.Errorf("pod %s exited before its plan was evaluated", podName)
+		}
+		if pod.Status.Phase == corev1.PodRunning {
+			logs, err := podLogs(clientset, namespace, podName)
+			if err != nil {
+				return PlanOutput{}, err
+			}
+			if end := strings.Index(logs, gateMarker+"\n"); end >= 0 {
+				return parsePlanLogs(logs[:end])
+			}
+		}
+		time.Sleep(gatePollInterval)
+	}
+}
+
+// SendVerdict lets a gated apply pod apply the plan it printed.
+func SendVerdict(clientset *kubernetes.Clientset, config *rest.Config, namespace, podName string) error {
+	return execInPod(clientset, config, namespace, podName, "terraform", []string{"/bin/sh", "-c", "echo apply > " + verdictFile})
+}
+
+// ExtendGate restarts the wait of a gated apply pod for its verdict, so it keeps its plan while
+// the controller runs the pre hooks of the apply.
+func ExtendGate(clientset *kubernetes.Clientset, config *rest.Config, namespace, podName string) error {
+	return execInPod(clientset, config, namespace, podName, "terraform", []string{"/bin/sh", "-c", "touch " + extendFile})
+}
+
+// podLogs returns the logs of the run container of a pod so far.
+func podLogs(clientset *kubernetes.Clientset, namespace, podName string) (string, error) {
+	logs, err := clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{Container: "terraform"}).Stream(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer logs.Close()
+
+	content, err := io.ReadAll(logs)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
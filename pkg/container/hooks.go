@@ -0,0 +1,138 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// hookPollInterval is how often a pod running post hooks is checked.
+const hookPollInterval = 10 * time.Second
+
+// Hook results.
+const (
+	HookSucceeded = "Succeeded"
+	HookFailed    = "Failed"
+	HookSkipped   = "Skipped"
+)
+
+// Hook is a step run in its own container of a run pod, with the environment and volumes of
+// the run. Without an image it runs in the run image, otherwise command replaces the
+// entrypoint of its image if set.
+type Hook struct {
+	Name    string
+	Image   string
+	Command []string
+}
+
+// HookResult is the outcome of a hook.
+type HookResult struct {
+	Name    string
+	State   string
+	Message string
+}
+
+// hookContainerName returns the name of the init container running the hook at the given index.
+func hookContainerName(index int) string {
+	return fmt.Sprintf("hook-%d", index)
+}
+
+// hookContainers returns the init containers running the hooks in order, sharing the
+// environment and volume mounts of the run container.
+func hookContainers(hooks []Hook, run v1.Container) []v1.Container {
+	containers := make([]v1.Container, 0, len(hooks))
+	for i, hook := range hooks {
+		container := v1.Container{
+			Name:         hookContainerName(i),
+			Image:        hook.Image,
+			Command:      hook.Command,
+			Env:          run.Env,
+			EnvFrom:      run.EnvFrom,
+			VolumeMounts: run.VolumeMounts,
+		}
+		if hook.Image == "" {
+			container.Image = run.Image
+			container.ImagePullPolicy = run.ImagePullPolicy
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// failedHook returns the name of the init container that failed in a pod, if any.
+func failedHook(pod *v1.Pod) (string, int32, bool) {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+			return status.Name, terminated.ExitCode, true
+		}
+	}
+	return "", 0, false
+}
+
+// HookResults returns the outcome of the hooks run by a completed pod. A hook after a failed one is skipped.
+func HookResults(clientset *kubernetes.Clientset, namespace, podName string, hooks []Hook) ([]HookResult, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]v1.ContainerStatus{}
+	for _, status := range pod.Status.InitContainerStatuses {
+		statuses[status.Name] = status
+	}
+
+	results := make([]HookResult, 0, len(hooks))
+	for i, hook := range hooks {
+		result := HookResult{Name: hook.Name, State: HookSkipped, Message: "not run"}
+		status, found := statuses[hookContainerName(i)]
+		if terminated := status.State.Terminated; found && terminated != nil {
+			if terminated.ExitCode == 0 {
+				result.State = HookSucceeded
+				result.Message = "completed"
+			} else {
+				result.State = HookFailed
+				result.Message = fmt.Sprintf("exited with code %d", terminated.ExitCode)
+				if line := containerLastLogLine(clientset, namespace, podName, hookContainerName(i)); line != "" {
+					result.Message += ": " + line
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// WaitForHooks waits for a pod created to run hooks to complete and returns their outcome.
+func WaitForHooks(clientset *kubernetes.Clientset, namespace, podName string, hooks []Hook) ([]HookResult, error) {
+	for {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			break
+		}
+		time.Sleep(hookPollInterval)
+	}
+	return HookResults(clientset, namespace, podName, hooks)
+}
+
+// containerLastLogLine returns the last log line of a container, empty if the logs cannot be read.
+func containerLastLogLine(clientset *kubernetes.Clientset, namespace, podName, containerName string) string {
+	logs, err := clientset.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{Container: containerName}).Stream(context.Background())
+	if err != nil {
+		return ""
+	}
+	defer logs.Close()
+
+	content, err := io.ReadAll(logs)
+	if err != nil {
+		return ""
+	}
+	return lastLogLine(string(content))
+}
@@ -0,0 +1,67 @@
+package container
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// importsFile holds the import blocks of the resource in the Terraform working directory
+	importsFile = "controller_imports.tf"
+	// generatedConfigFile is where plans write the configuration generated for imports
+	generatedConfigFile = "controller_generated.tf"
+	// GeneratedConfigKey is the key of the generated configuration in its ConfigMap
+	GeneratedConfigKey = "generated.tf"
+)
+
+// ImportInputs returns the run pod inputs writing the import blocks into the Terraform working directory.
+func ImportInputs(imports string) RunPodInputs {
+	return RunPodInputs{
+		Setup: fmt.Sprintf(`printf '%%s' %s > "${WORKING_DIR:-.}/%s" || exit 1`, shellQuote(imports), importsFile),
+	}
+}
+
+// GeneratedConfigMapName returns the name of the ConfigMap holding the configuration generated
+// for the imports of a resource.
+func GeneratedConfigMapName(name string) string {
+	return fmt.Sprintf("%s-generated-config", name)
+}
+
+// ApplyGeneratedConfigMap stores the configuration generated for the imports of a resource and
+// returns the name of its ConfigMap.
+func ApplyGeneratedConfigMap(clientset *kubernetes.Clientset, name, namespace string, owner metav1.OwnerReference, content string) (string, error) {
+	if len(content) > planChunkSize {
+		return "", fmt.Errorf("generated configuration of %d bytes is too large to store", len(content))
+	}
+	configMapName := GeneratedConfigMapName(name)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            configMapName,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Data: map[string]string{GeneratedConfigKey: content},
+	}
+
+	existing, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err := clientset.CoreV1().ConfigMaps(namespace).Create(context.Background(), configMap, metav1.CreateOptions{}); err != nil {
+			return "", fmt.Errorf("failed to create generated configuration ConfigMap: %v", err)
+		}
+		return configMapName, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get generated configuration ConfigMap: %v", err)
+	}
+	existing.OwnerReferences = configMap.OwnerReferences
+	existing.Data = configMap.Data
+	if _, err := clientset.CoreV1().ConfigMaps(namespace).Update(context.Background(), existing, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to update generated configuration ConfigMap: %v", err)
+	}
+	return configMapName, nil
+}
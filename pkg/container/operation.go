@@ -0,0 +1,66 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Types of one-shot operations.
+const (
+	OperationPlan  = "plan"
+	OperationApply = "apply"
+)
+
+// Operation is a one-shot Terraform plan or apply narrowed with -target, -replace or -refresh-only.
+type Operation struct {
+	Type        string
+	Targets     []string
+	Replace     []string
+	RefreshOnly bool
+}
+
+// Validate checks the operation before its arguments are written into the command.
+func (o Operation) Validate() error {
+	if o.Type != OperationPlan && o.Type != OperationApply {
+		return fmt.Errorf("unsupported operation %q, expected plan or apply", o.Type)
+	}
+	if o.RefreshOnly && len(o.Replace) > 0 {
+		return fmt.Errorf("replace cannot be combined with refreshOnly")
+	}
+	for _, address := range append(append([]string{}, o.Targets...), o.Replace...) {
+		if strings.TrimSpace(address) == "" || strings.ContainsAny(address, "\n\r") {
+			return fmt.Errorf("invalid resource address %q", address)
+		}
+	}
+	return nil
+}
+
+// flags returns the plan and apply flags of the operation, shell quoted.
+func (o Operation) flags() string {
+	var flags []string
+	if o.RefreshOnly {
+		flags = append(flags, "-refresh-only")
+	}
+	for _, target := range o.Targets {
+		flags = append(flags, shellQuote("-target="+target))
+	}
+	for _, address := range o.Replace {
+		flags = append(flags, shellQuote("-replace="+address))
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(flags, " ")
+}
+
+// Command returns the run pod command of the operation. A plan prints the same output as
+// PlanCommand for WaitForPlan. An apply is gated like the applies of the runner: it prints its
+// plan for WaitForGate and applies exactly that plan once SendVerdict was called, printing the
+// outputs on the last line.
+func (o Operation) Command(r Runner) []string {
+	if o.Type == OperationPlan {
+		return []string{"/bin/bash", "-c", planScript(o.flags(), false)}
+	}
+	r.GenerateConfig = false
+	return r.GateCommand(o.flags())
+}
@@ -0,0 +1,204 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/runner"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// sensitiveOutputsFile is where gated apply pods write the values of their sensitive outputs
+	// for WaitForSensitiveOutputs
+	sensitiveOutputsFile = "/tmp/controller-outputs.json"
+	// sensitiveOutputsTimeoutSeconds is how long a gated apply pod waits for its sensitive outputs to be read
+	sensitiveOutputsTimeoutSeconds = "120"
+)
+
+// redactOutputsJq replaces the values of the sensitive outputs of `terraform output -json`,
+// like the builtin runner does.
+const redactOutputsJq = `with_entries(if .value.sensitive then .value.value = "` + runner.RedactedValue + `" else . end)`
+
+// printOutputsScript prints the outputs of `terraform output -json` saved in the file named by
+// $outputs as JSON on the last line, the values of sensitive outputs redacted, like the builtin
+// runner does. Those values are written to sensitiveOutputsFile first and the pod waits until
+// WaitForSensitiveOutputs read them. With byUnit the outputs are keyed by unit, and so are the
+// sensitive ones, as <unit>/<output>.
+func printOutputsScript(byUnit bool) string {
+	redact := redactOutputsJq
+	extract := `with_entries(select(.value.sensitive) | .value |= .value)`
+	if byUnit {
+		redact = `map_values(` + redactOutputsJq + `)`
+		extract = `[to_entries[] | .key as $unit | .value | to_entries[] | select(.value.sensitive) | {key: ($unit + "/" + .key), value: .value.value}] | from_entries`
+	}
+	return `jq -c '` + extract + `' "$outputs" > ` + sensitiveOutputsFile + ` || exit 1
+if [ "$(cat ` + sensitiveOutputsFile + `)" != '{}' ]; then
+  echo '` + runner.SensitiveOutputsMarker + `' >&2
+  waited=0
+  until [ -f ` + sensitiveOutputsFile + runner.SensitiveOutputsReadSuffix + ` ] || [ "$waited" -ge ` + sensitiveOutputsTimeoutSeconds + ` ]; do
+    sleep 1
+    waited=$((waited + 1))
+  done
+  [ -f ` + sensitiveOutputsFile + runner.SensitiveOutputsReadSuffix + ` ] || echo "sensitive outputs were not read by the controller" >&2
+fi
+rm -f ` + sensitiveOutputsFile + ` ` + sensitiveOutputsFile + runner.SensitiveOutputsReadSuffix + `
+jq -c '` + redact + `' "$outputs" || exit 1
+`
+}
+
+// dependencyOutputsOfLabel marks the Secrets passing sensitive dependency outputs to the run pods of a resource.
+const dependencyOutputsOfLabel = "alustan.io/dependency-outputs-of"
+
+// WaitForSensitiveOutputs waits until a gated apply pod, once sent its verdict, wrote the values
+// of its sensitive outputs, reads them and lets the pod end. It returns nil once the pod ended without
+// writing any, e.g. because it has none.
+func WaitForSensitiveOutputs(clientset *kubernetes.Clientset, config *rest.Config, namespace, podName string) (map[string]interface{}, error) {
+	for {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			return nil, nil
+		}
+		// The pod writes nothing after the marker until the values were read
+		if tail, err := podLogTail(clientset, namespace, podName, 3); err == nil && strings.Contains(tail, runner.SensitiveOutputsMarker) {
+			content, err := execInPodOutput(clientset, config, namespace, podName, "terraform", []string{"cat", sensitiveOutputsFile})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read sensitive outputs: %v", err)
+			}
+			if err := execInPod(clientset, config, namespace, podName, "terraform", []string{"touch", sensitiveOutputsFile + runner.SensitiveOutputsReadSuffix}); err != nil {
+				return nil, fmt.Errorf("failed to release the outputs of pod %s: %v", podName, err)
+			}
+			var values map[string]interface{}
+			if err := json.Unmarshal([]byte(content), &values); err != nil {
+				return nil, fmt.Errorf("invalid sensitive outputs: %v", err)
+			}
+			return values, nil
+		}
+		time.Sleep(gatePollInterval)
+	}
+}
+
+// podLogTail returns the last lines of the logs of the run container of a pod.
+func podLogTail(clientset *kubernetes.Clientset, namespace, podName string, lines int64) (string, error) {
+	content, err := clientset.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{Container: "terraform", TailLines: &lines}).DoRaw(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// outputsSecretName returns the name of the Secret holding the sensitive outputs of a resource.
+func outputsSecretName(name string) string {
+	return fmt.Sprintf("%s-outputs", name)
+}
+
+// ApplyOutputsSecret stores the values of the sensitive outputs of a resource in its outputs
+// Secret, which is owned by the resource, and deletes the Secret if it has none.
+func ApplyOutputsSecret(clientset *kubernetes.Clientset, name, namespace string, owner metav1.OwnerReference, values map[string]string) error {
+	secrets := clientset.CoreV1().Secrets(namespace)
+	secretName := outputsSecretName(name)
+
+	if len(values) == 0 {
+		err := secrets.Delete(context.Background(), secretName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete outputs Secret: %v", err)
+		}
+		return nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            secretName,
+			Namespace:       namespace,
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: values,
+	}
+	existing, err := secrets.Get(context.Background(), secretName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = secrets.Create(context.Background(), secret, metav1.CreateOptions{})
+	case err == nil:
+		secret.ResourceVersion = existing.ResourceVersion
+		_, err = secrets.Update(context.Background(), secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store outputs Secret: %v", err)
+	}
+	return nil
+}
+
+// ReadOutputsSecret returns the values of the sensitive outputs of a resource, empty if it has none.
+func ReadOutputsSecret(clientset *kubernetes.Clientset, name, namespace string) (map[string]string, error) {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), outputsSecretName(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outputs Secret: %v", err)
+	}
+	values := make(map[string]string, len(secret.Data))
+	for key, value := range secret.Data {
+		values[key] = string(value)
+	}
+	return values, nil
+}
+
+// CreateDependencyOutputsSecret creates a Secret holding the sensitive outputs of the
+// dependencies of a resource for one run pod, keyed by the environment variable they set, and
+// returns the run pod inputs setting them. Like the SOPS Secrets it is owned by the resource and
+// deleted with DeleteInputSecrets as soon as the run pod finished.
+func CreateDependencyOutputsSecret(clientset *kubernetes.Clientset, name, namespace string, owner metav1.OwnerReference, envVars map[string]string) (RunPodInputs, error) {
+	if len(envVars) == 0 {
+		return RunPodInputs{}, nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName:    fmt.Sprintf("%s-dependency-outputs-", name),
+			Namespace:       namespace,
+			Labels:          map[string]string{dependencyOutputsOfLabel: name},
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: envVars,
+	}
+	created, err := clientset.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	if err != nil {
+		return RunPodInputs{}, fmt.Errorf("failed to create dependency outputs Secret: %v", err)
+	}
+	log.Printf("Created Secret: %s", created.Name)
+
+	names := make([]string, 0, len(envVars))
+	for envVar := range envVars {
+		names = append(names, envVar)
+	}
+	sort.Strings(names)
+
+	inputs := RunPodInputs{Secrets: []string{created.Name}}
+	for _, envVar := range names {
+		inputs.Env = append(inputs.Env, corev1.EnvVar{
+			Name: envVar,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: created.Name},
+					Key:                  envVar,
+				},
+			},
+		})
+	}
+	return inputs, nil
+}
@@ -0,0 +1,207 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	planTextBegin = "----- BEGIN TERRAFORM PLAN -----"
+	planTextEnd   = "----- END TERRAFORM PLAN -----"
+
+	generatedConfigBegin = "----- BEGIN GENERATED CONFIGURATION -----"
+	generatedConfigEnd   = "----- END GENERATED CONFIGURATION -----"
+
+	// planChunkSize keeps each plan ConfigMap under the 1MiB object size limit
+	planChunkSize = 900 * 1024
+	// planMaxChunks caps the stored plan text, longer plans are truncated
+	planMaxChunks = 8
+	// planOfLabel labels the ConfigMaps holding the plan text of a resource with its name
+	planOfLabel         = "alustan.io/plan-of"
+	planChunkAnnotation = "alustan.io/plan-chunk"
+	planTextKey         = "plan.txt"
+)
+
+// planSummaryJq defines the jq function plan_summary, reducing the output of `terraform show
+// -json` for a saved plan to its resource and output changes. Values Terraform marks sensitive
+// are replaced with "(sensitive)" and the variables, prior state, planned values and
+// configuration are left out, so the plan printed to the pod logs holds no secrets.
+const planSummaryJq = `def redact($s):
+  if $s == true then "(sensitive)"
+  elif ($s | type) == "object" and type == "object" then with_entries(.key as $k | .value |= redact($s[$k] // false))
+  elif ($s | type) == "array" and type == "array" then [range(0; length) as $i | .[$i] | redact($s[$i] // false)]
+  else . end;
+def plan_summary: {
+  format_version,
+  terraform_version,
+  resource_changes: [(.resource_changes // [])[] | {
+    address, module_address, mode, type, name, index, provider_name,
+    change: (.change as $c | $c | {
+      actions, importing, after_unknown,
+      before: (.before | redact($c.before_sensitive // false)),
+      after: (.after | redact($c.after_sensitive // false))
+    })
+  }],
+  output_changes: ((.output_changes // {}) | map_values({actions}))
+};
+`
+
+// planScript saves a plan made with the given extra flags and prints it, first as text between
+// markers, then as JSON reduced by plan_summary on the last line read by WaitForPlan. With generateConfig Terraform
+// writes configuration for the imports without one, printed between markers too. Terraform
+// init and plan output goes to stderr.
+func planScript(flags string, generateConfig bool) string {
+	generated := ""
+	if generateConfig {
+		flags += " -generate-config-out=" + generatedConfigFile
+		generated = `if [ -f ` + generatedConfigFile + ` ]; then
+  echo '` + generatedConfigBegin + `'
+  cat ` + generatedConfigFile + `
+  echo '` + generatedConfigEnd + `'
+fi
+`
+	}
+	return `cd "${WORKING_DIR:-.}" || exit 1
+terraform init -input=false 1>&2 || exit 1
+terraform plan -input=false -lock=false -out=controller.tfplan` + flags + ` 1>&2 || exit 1
+` + generated + `echo '` + planTextBegin + `'
+terraform show -no-color controller.tfplan || exit 1
+echo '` + planTextEnd + `'
+plan=$(terraform show -json -no-color controller.tfplan) || exit 1
+jq -c '` + planSummaryJq + `plan_summary' <<<"$plan" || exit 1
+`
+}
+
+// PlanCommand returns the run pod command used for plans before applies and for drift checks.
+func PlanCommand(generateConfig bool) []string {
+	return []string{"/bin/bash", "-c", planScript("", generateConfig)}
+}
+
+// PlanOutput is what a plan pod printed.
+type PlanOutput struct {
+	JSON map[string]interface{}
+	Text string
+	// GeneratedConfig is the configuration Terraform generated for imports, empty if none was
+	GeneratedConfig string
+}
+
+// WaitForPlan waits for a plan pod to complete and returns the JSON plan, its text and the
+// configuration generated for imports.
+func WaitForPlan(clientset *kubernetes.Clientset, namespace, podName string) (PlanOutput, error) {
+	logs, err := waitForPodLogs(clientset, namespace, podName, 2*time.Minute)
+	if err != nil {
+		return PlanOutput{}, err
+	}
+	return parsePlanLogs(logs)
+}
+
+// parsePlanLogs reads the plan printed by planScript.
+func parsePlanLogs(logs string) (PlanOutput, error) {
+	var plan map[string]interface{}
+	if err := json.Unmarshal([]byte(lastLogLine(logs)), &plan); err != nil {
+		return PlanOutput{}, fmt.Errorf("failed to parse Terraform plan: %v", err)
+	}
+
+	return PlanOutput{
+		JSON:            plan,
+		Text:            betweenMarkers(logs, planTextBegin, planTextEnd),
+		GeneratedConfig: betweenMarkers(logs, generatedConfigBegin, generatedConfigEnd),
+	}, nil
+}
+
+// betweenMarkers returns the log lines printed between a begin and an end marker.
+func betweenMarkers(logs, begin, end string) string {
+	start := strings.Index(logs, begin+"\n")
+	if start < 0 {
+		return ""
+	}
+	text := logs[start+len(begin)+1:]
+	if stop := strings.Index(text, end); stop >= 0 {
+		text = text[:stop]
+	}
+	return text
+}
+
+// ApplyPlanConfigMaps stores the plan text of a resource in as many ConfigMaps as its size needs,
+// named <name>-plan-<n>, and deletes the chunks of a previous, longer plan. ConfigMaps with these
+// names not labelled as plans of the resource are left alone.
+func ApplyPlanConfigMaps(clientset *kubernetes.Clientset, name, namespace string, owner metav1.OwnerReference, text string) ([]string, error) {
+	var chunks []string
+	for len(text) > 0 && len(chunks) < planMaxChunks {
+		size := planChunkSize
+		if size >= len(text) {
+			size = len(text)
+		} else {
+			// ConfigMap data must be valid UTF-8, so chunks never split a character
+			for size > 0 && !utf8.RuneStart(text[size]) {
+				size--
+			}
+		}
+		chunks = append(chunks, text[:size])
+		text = text[size:]
+	}
+	if len(text) > 0 {
+		chunks[len(chunks)-1] += "\n(plan truncated)\n"
+	}
+
+	var names []string
+	for i, chunk := range chunks {
+		configMapName := fmt.Sprintf("%s-plan-%d", name, i)
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            configMapName,
+				Namespace:       namespace,
+				Labels:          map[string]string{planOfLabel: name},
+				Annotations:     map[string]string{planChunkAnnotation: strconv.Itoa(i)},
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Data: map[string]string{planTextKey: chunk},
+		}
+
+		existing, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configMapName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			if _, err := clientset.CoreV1().ConfigMaps(namespace).Create(context.Background(), configMap, metav1.CreateOptions{}); err != nil {
+				return nil, fmt.Errorf("failed to create plan ConfigMap: %v", err)
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get plan ConfigMap: %v", err)
+		} else if existing.Labels[planOfLabel] != name {
+			return nil, fmt.Errorf("ConfigMap %s exists and does not hold a plan of %s, not replacing it", configMapName, name)
+		} else {
+			existing.Labels = configMap.Labels
+			existing.Annotations = configMap.Annotations
+			existing.OwnerReferences = configMap.OwnerReferences
+			existing.Data = configMap.Data
+			if _, err := clientset.CoreV1().ConfigMaps(namespace).Update(context.Background(), existing, metav1.UpdateOptions{}); err != nil {
+				return nil, fmt.Errorf("failed to update plan ConfigMap: %v", err)
+			}
+		}
+		names = append(names, configMapName)
+	}
+
+	stale, err := clientset.CoreV1().ConfigMaps(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", planOfLabel, name)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plan ConfigMaps: %v", err)
+	}
+	for _, configMap := range stale.Items {
+		if index, err := strconv.Atoi(configMap.Annotations[planChunkAnnotation]); err == nil && index < len(chunks) {
+			continue
+		}
+		if err := clientset.CoreV1().ConfigMaps(namespace).Delete(context.Background(), configMap.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to delete plan ConfigMap %s: %v", configMap.Name, err)
+		}
+		log.Printf("Deleted stale plan ConfigMap: %s", configMap.Name)
+	}
+	return names, nil
+}
@@ -3,6 +3,7 @@ package container
 import (
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "log"
@@ -10,14 +11,80 @@ import (
     "time"
 
     v1 "k8s.io/api/core/v1"
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     "k8s.io/client-go/kubernetes"
 )
 
 
+// RunPodInputs carries the inputs of a run pod besides its inline environment variables.
+type RunPodInputs struct {
+    // EnvFrom exposes whole ConfigMaps and Secrets as environment variables
+    EnvFrom []v1.EnvFromSource
+    // Env holds environment variables read from selected ConfigMap and Secret keys
+    Env []v1.EnvVar
+    // Volumes and VolumeMounts add files to the run pod
+    Volumes      []v1.Volume
+    VolumeMounts []v1.VolumeMount
+    // Setup is a shell snippet run from the image working directory before the script or command
+    Setup string
+    // Hooks run in order as init containers before the setup, a failing hook fails the pod
+    Hooks []Hook
+    // Secrets lists the Secrets created for the pod, deleted by DeleteInputSecrets once it finished
+    Secrets []string
+}
+
+// With returns the inputs combined with other, whose setup runs after the setup of the inputs.
+func (in RunPodInputs) With(other RunPodInputs) RunPodInputs {
+    setup := in.Setup
+    if setup != "" && other.Setup != "" {
+        setup += "\n"
+    }
+    return RunPodInputs{
+        EnvFrom:      append(append([]v1.EnvFromSource{}, in.EnvFrom...), other.EnvFrom...),
+        Env:          append(append([]v1.EnvVar{}, in.Env...), other.Env...),
+        Volumes:      append(append([]v1.Volume{}, in.Volumes...), other.Volumes...),
+        VolumeMounts: append(append([]v1.VolumeMount{}, in.VolumeMounts...), other.VolumeMounts...),
+        Setup:        setup + other.Setup,
+        Hooks:        append(append([]Hook{}, in.Hooks...), other.Hooks...),
+        Secrets:      append(append([]string{}, in.Secrets...), other.Secrets...),
+    }
+}
+
+// defaultRunCommand is the image command, running the script named by $SCRIPT.
+var defaultRunCommand = []string{"/bin/bash", "-c", "chmod +x $SCRIPT && exec $SCRIPT"}
+
 // CreateRunPod creates a Kubernetes Pod that runs a script with specified environment variables and image.
-func CreateRunPod(clientset *kubernetes.Clientset, name, namespace, scriptName string, envVars map[string]string, taggedImageName, imagePullSecretName string) (string, error) {
-    labelSelector := fmt.Sprintf("apprun=%s", name)
+// If command is set it replaces the image command and no script is passed.
+func CreateRunPod(clientset *kubernetes.Clientset, name, namespace, scriptName string, envVars map[string]string, taggedImageName, imagePullSecretName string, command []string, inputs RunPodInputs) (string, error) {
+    return createPod(clientset, "apprun", "docker-run-pod", name, namespace, scriptName, envVars, taggedImageName, imagePullSecretName, command, inputs)
+}
+
+// CreateInspectionPod is CreateRunPod for pods only reading the state of a resource. They are
+// labelled appinspect instead of apprun, so they neither block nor are cancelled with runs.
+func CreateInspectionPod(clientset *kubernetes.Clientset, name, namespace string, envVars map[string]string, taggedImageName, imagePullSecretName string, command []string, inputs RunPodInputs) (string, error) {
+    return createPod(clientset, "appinspect", "inspect-pod", name, namespace, "", envVars, taggedImageName, imagePullSecretName, command, inputs)
+}
+
+// CreateHookPod is CreateRunPod for the pre hooks of a gated apply, which run while its apply pod
+// waits for the verdict. They are labelled apphook instead of apprun, so the apply pod does not
+// block them, and are deleted when the run is cancelled.
+func CreateHookPod(clientset *kubernetes.Clientset, name, namespace string, envVars map[string]string, taggedImageName, imagePullSecretName string, command []string, inputs RunPodInputs) (string, error) {
+    return createPod(clientset, "apphook", "hook-pod", name, namespace, "", envVars, taggedImageName, imagePullSecretName, command, inputs)
+}
+
+// DeletePod deletes a completed pod once its logs were read.
+func DeletePod(clientset *kubernetes.Clientset, namespace, podName string) error {
+    err := clientset.CoreV1().Pods(namespace).Delete(context.Background(), podName, metav1.DeleteOptions{})
+    if err != nil && !apierrors.IsNotFound(err) {
+        return fmt.Errorf("failed to delete pod %s: %v", podName, err)
+    }
+    return nil
+}
+
+// createPod creates a pod labelled label=name, unless an active pod with that label exists.
+func createPod(clientset *kubernetes.Clientset, label, kind, name, namespace, scriptName string, envVars map[string]string, taggedImageName, imagePullSecretName string, command []string, inputs RunPodInputs) (string, error) {
+    labelSelector := fmt.Sprintf("%s=%s", label, name)
 
     // Check for existing pods with the same label
     exists, err := CheckExistingPods(clientset, namespace, labelSelector)
@@ -33,7 +100,7 @@ func CreateRunPod(clientset *kubernetes.Clientset, name, namespace, scriptName s
 
     // Generate a unique pod name using the current timestamp
     timestamp := time.Now().Format("20060102150405")
-    podName := fmt.Sprintf("%s-docker-run-pod-%s", name, timestamp)
+    podName := fmt.Sprintf("%s-%s-%s", name, kind, timestamp)
 
     log.Printf("Creating Pod in namespace: %s with image: %s", namespace, taggedImageName)
 
@@ -43,49 +110,63 @@ func CreateRunPod(clientset *kubernetes.Clientset, name, namespace, scriptName s
             Name:  key,
             Value: value,
         })
-        log.Printf("Setting environment variable %s=%s", key, value)
+        log.Printf("Setting environment variable %s", key)
     }
+    env = append(env, inputs.Env...)
 
    // Add the script name as an environment variable
-    env = append(env, v1.EnvVar{
-        Name:  "SCRIPT",
-        Value: "./" + scriptName,  
-    })
+    if scriptName != "" {
+        env = append(env, v1.EnvVar{
+            Name:  "SCRIPT",
+            Value: "./" + scriptName,
+        })
+    }
+
+    // Run the setup before handing over to the script or command
+    if inputs.Setup != "" {
+        if command == nil {
+            command = defaultRunCommand
+        }
+        command = append([]string{"/bin/bash", "-c", inputs.Setup + "\nexec \"$@\"", "setup"}, command...)
+    }
+
+    run := v1.Container{
+        Name:            "terraform",
+        Image:           taggedImageName,
+        ImagePullPolicy: v1.PullAlways,
+        Command:         command,
+        Env:             env,
+        EnvFrom:         inputs.EnvFrom,
+        VolumeMounts: append([]v1.VolumeMount{
+            {
+                Name:      "workspace",
+                MountPath: "/workspace",
+            },
+        }, inputs.VolumeMounts...),
+    }
 
     pod := &v1.Pod{
         ObjectMeta: metav1.ObjectMeta{
             Name: podName,
             Labels: map[string]string{
-                "apprun": name,
+                label: name,
             },
             Annotations: map[string]string{
                 "kubectl.kubernetes.io/ttl": "3600", // TTL in seconds (1 hour)
             },
         },
         Spec: v1.PodSpec{
-            Containers: []v1.Container{
-                {
-                    Name:            "terraform",
-                    Image:           taggedImageName,
-                    ImagePullPolicy: v1.PullAlways,
-                    Env:             env,
-                    VolumeMounts: []v1.VolumeMount{
-                        {
-                            Name:      "workspace",
-                            MountPath: "/workspace",
-                        },
-                    },
-                },
-            },
+            InitContainers: hookContainers(inputs.Hooks, run),
+            Containers:     []v1.Container{run},
             RestartPolicy: v1.RestartPolicyNever,
-            Volumes: []v1.Volume{
+            Volumes: append([]v1.Volume{
                 {
                     Name: "workspace",
                     VolumeSource: v1.VolumeSource{
                         EmptyDir: &v1.EmptyDirVolumeSource{},
                     },
                 },
-            },
+            }, inputs.Volumes...),
             ImagePullSecrets: []v1.LocalObjectReference{
                 {
                     Name: imagePullSecretName,
@@ -106,44 +187,93 @@ func CreateRunPod(clientset *kubernetes.Clientset, name, namespace, scriptName s
 }
 
 // WaitForPodCompletion waits for the pod to complete and retrieves the Terraform output.
+// A pod that failed is reported as an error carrying its last log line.
 func WaitForPodCompletion(clientset *kubernetes.Clientset, namespace, podName string) (map[string]interface{}, error) {
+    return WaitForPodCompletionEvery(clientset, namespace, podName, 2*time.Minute)
+}
+
+// WaitForPodCompletionEvery is WaitForPodCompletion checking the pod at the given interval,
+// for short-lived pods whose result is awaited by a client.
+func WaitForPodCompletionEvery(clientset *kubernetes.Clientset, namespace, podName string, interval time.Duration) (map[string]interface{}, error) {
+    lastLine, err := waitForPodSuccess(clientset, namespace, podName, interval)
+    if err != nil {
+        return nil, err
+    }
+
+    var output map[string]interface{}
+    err = json.Unmarshal([]byte(lastLine), &output)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse Terraform output: %v", err)
+    }
+
+    return output, nil
+}
+
+// WaitForPodSuccess waits for the pod to complete and returns the last line of its logs,
+// or an error carrying that line if the pod failed.
+func WaitForPodSuccess(clientset *kubernetes.Clientset, namespace, podName string) (string, error) {
+    return waitForPodSuccess(clientset, namespace, podName, 2*time.Minute)
+}
+
+func waitForPodSuccess(clientset *kubernetes.Clientset, namespace, podName string, interval time.Duration) (string, error) {
+    logs, err := waitForPodLogs(clientset, namespace, podName, interval)
+    if err != nil {
+        return "", err
+    }
+    return lastLogLine(logs), nil
+}
+
+// waitForPodLogs waits for the pod to complete and returns its logs,
+// or an error carrying their last line if the pod failed.
+func waitForPodLogs(clientset *kubernetes.Clientset, namespace, podName string, interval time.Duration) (string, error) {
+    var pod *v1.Pod
     for {
-        pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+        var err error
+        pod, err = clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
         if err != nil {
-            return nil, err
+            return "", err
         }
         if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
             break
         }
-        time.Sleep(2 * time.Minute)
+        time.Sleep(interval)
     }
+    phase := pod.Status.Phase
 
-    req := clientset.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{})
+    // The run container never started if one of the hooks failed
+    if hook, exitCode, failed := failedHook(pod); failed {
+        message := fmt.Sprintf("pod %s failed: hook container %s exited with code %d", podName, hook, exitCode)
+        if line := containerLastLogLine(clientset, namespace, podName, hook); line != "" {
+            message += ": " + line
+        }
+        return "", errors.New(message)
+    }
+
+    req := clientset.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{Container: pod.Spec.Containers[0].Name})
     logs, err := req.Stream(context.Background())
     if err != nil {
-        return nil, err
+        return "", err
     }
     defer logs.Close()
 
     logsBytes, err := io.ReadAll(logs)
     if err != nil {
-        return nil, err
+        return "", err
     }
 
     logsString := string(logsBytes)
+    if phase == v1.PodFailed {
+        return "", fmt.Errorf("pod %s failed: %s", podName, lastLogLine(logsString))
+    }
+    return logsString, nil
+}
 
-    // Assuming the JSON output is printed as the last line of the logs
-    lines := strings.Split(logsString, "\n")
+// lastLogLine returns the last line of the logs, where the JSON output is printed.
+func lastLogLine(logs string) string {
+    lines := strings.Split(logs, "\n")
     lastLine := lines[len(lines)-1]
     if lastLine == "" && len(lines) > 1 {
         lastLine = lines[len(lines)-2]
     }
-
-    var output map[string]interface{}
-    err = json.Unmarshal([]byte(lastLine), &output)
-    if err != nil {
-        return nil, fmt.Errorf("failed to parse Terraform output: %v", err)
-    }
-
-    return output, nil
+    return lastLine
 }
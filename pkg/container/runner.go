@@ -0,0 +1,93 @@
+package container
+
+import (
+	"fmt"
+
+	"github.com/alustan/terraform-controller/pkg/runner"
+)
+
+// runnerBinary is where the builtin runner is installed in the image.
+const runnerBinary = "/usr/local/bin/terraform-runner"
+
+// Runner selects the commands of the run pods. The script runner runs the deploy and destroy
+// scripts of the resource; the Terragrunt runner runs Terragrunt in the working directory, on
+// the single unit it holds or, with RunAll, on every unit below it; the builtin runner runs the
+// Terraform lifecycle with the runner binary. GenerateConfig makes plans generate configuration
+// for the imports without one.
+type Runner struct {
+	Terragrunt     bool
+	RunAll         bool
+	Builtin        bool
+	GenerateConfig bool
+}
+
+// SavedPlan reports whether the runner applies the plan it printed, with GateCommand. The
+// script runner runs the deploy script instead, which plans again.
+func (r Runner) SavedPlan() bool {
+	return r.Builtin || r.Terragrunt
+}
+
+// DestroyCommand returns the command of destroy pods, nil to run the destroy script.
+func (r Runner) DestroyCommand() []string {
+	switch {
+	case r.Builtin:
+		return builtinCommand("destroy", "")
+	case r.Terragrunt:
+		return r.terragruntCommand(terragruntDestroyScript)
+	}
+	return nil
+}
+
+// PlanCommand returns the command of plan pods.
+func (r Runner) PlanCommand() []string {
+	if !r.Terragrunt {
+		return PlanCommand(r.GenerateConfig)
+	}
+	return r.terragruntCommand(terragruntPlanScript)
+}
+
+// ShowStateCommand returns the command of state inspection pods.
+func (r Runner) ShowStateCommand() []string {
+	if !r.Terragrunt {
+		return ShowStateCommand()
+	}
+	return r.terragruntCommand(terragruntShowStateScript)
+}
+
+// StateCountCommand returns the command of destroy verification pods.
+func (r Runner) StateCountCommand() []string {
+	if !r.Terragrunt {
+		return StateCountCommand()
+	}
+	return r.terragruntCommand(terragruntStateCountScript)
+}
+
+// Outputs returns the Terraform outputs from the last log line of an apply pod, with the values
+// of sensitive outputs redacted, and the values of the sensitive outputs it carried. The builtin
+// runner ends with a result event carrying them, the other runners print them as is. Gated apply
+// pods redact them themselves and pass their values with WaitForSensitiveOutputs instead.
+func (r Runner) Outputs(result map[string]interface{}) (outputs, sensitive map[string]interface{}) {
+	if !r.Builtin {
+		return runner.RedactOutputs(result)
+	}
+	outputs, _ = result["outputs"].(map[string]interface{})
+	return runner.RedactOutputs(outputs)
+}
+
+// builtinCommand returns the runner command of an operation. An apply of planFile applies that
+// saved plan instead of planning, it is gated and passes the values of the sensitive outputs
+// with WaitForSensitiveOutputs.
+func builtinCommand(operation, planFile string) []string {
+	command := []string{runnerBinary}
+	if planFile != "" {
+		command = append(command, "-plan", planFile, "-sensitive-outputs", sensitiveOutputsFile)
+	}
+	return append(command, operation)
+}
+
+// runnerInstallStep returns the Dockerfile step copying the builtin runner from its image.
+func runnerInstallStep(image string) string {
+	return fmt.Sprintf(`
+COPY --from=%s /terraform-runner %s
+`, image, runnerBinary)
+}
@@ -0,0 +1,32 @@
+package container
+
+// stateSummaryJq defines the jq function state_summary, reducing the output of `terraform show
+// -json` to what the state summary lists: resource addresses without their attributes, and
+// outputs whose sensitive values are redacted. It runs in the pod, so the state never reaches
+// the pod logs.
+const stateSummaryJq = `def state_module: {
+  address,
+  resources: [(.resources // [])[] | {address, mode, type, name, provider_name}],
+  child_modules: [(.child_modules // [])[] | state_module]
+};
+def state_summary: {
+  terraform_version,
+  values: (if .values == null then null else {
+    outputs: ((.values.outputs // {}) | with_entries(.value |= {sensitive, type, value: (if .sensitive then "(sensitive value)" else .value end)})),
+    root_module: ((.values.root_module // {}) | state_module)
+  } end)
+};
+`
+
+// showStateScript prints the summary of the current Terraform state as JSON. Terraform init
+// output goes to stderr so the last log line is the JSON result read by WaitForPodCompletion.
+const showStateScript = `cd "${WORKING_DIR:-.}" || exit 1
+terraform init -input=false 1>&2 || exit 1
+state=$(terraform show -json -no-color) || exit 1
+jq -c '` + stateSummaryJq + `state_summary' <<<"$state" || exit 1
+`
+
+// ShowStateCommand returns the run pod command used to inspect the current state.
+func ShowStateCommand() []string {
+	return []string{"/bin/bash", "-c", showStateScript}
+}
@@ -0,0 +1,87 @@
+package container
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Terraform distributions installed in the image.
+const (
+	DistributionTerraform = "terraform"
+	DistributionOpenTofu  = "opentofu"
+)
+
+// DefaultTerraformVersion is installed when no version is requested.
+const DefaultTerraformVersion = "1.8.1"
+
+var (
+	versionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.]+)?$`)
+	sha256Pattern  = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// TerraformBinary is the Terraform or OpenTofu release installed in the image. Without SHA256
+// the archive is verified against the SHA256SUMS file published with the release.
+type TerraformBinary struct {
+	Distribution string
+	Version      string
+	SHA256       string
+}
+
+// Resolved returns the binary with the default distribution and version filled in.
+func (b TerraformBinary) Resolved() TerraformBinary {
+	if b.Distribution == "" {
+		b.Distribution = DistributionTerraform
+	}
+	if b.Version == "" {
+		b.Version = DefaultTerraformVersion
+	}
+	return b
+}
+
+// Validate checks the binary before its values are written into the Dockerfile.
+func (b TerraformBinary) Validate() error {
+	b = b.Resolved()
+	if b.Distribution != DistributionTerraform && b.Distribution != DistributionOpenTofu {
+		return fmt.Errorf("unsupported distribution %q, expected terraform or opentofu", b.Distribution)
+	}
+	if !versionPattern.MatchString(b.Version) {
+		return fmt.Errorf("invalid version %q, expected a release version like 1.8.1", b.Version)
+	}
+	if b.SHA256 != "" && !sha256Pattern.MatchString(b.SHA256) {
+		return fmt.Errorf("invalid sha256 %q, expected 64 lowercase hex characters", b.SHA256)
+	}
+	return nil
+}
+
+// installStep returns the Dockerfile step downloading, verifying and installing the binary.
+// OpenTofu is also linked as terraform so scripts and the controller keep calling terraform.
+func (b TerraformBinary) installStep() string {
+	b = b.Resolved()
+
+	archive := fmt.Sprintf("terraform_%s_linux_amd64.zip", b.Version)
+	baseURL := fmt.Sprintf("https://releases.hashicorp.com/terraform/%s", b.Version)
+	sums := fmt.Sprintf("terraform_%s_SHA256SUMS", b.Version)
+	binary := "terraform"
+	link := ""
+	if b.Distribution == DistributionOpenTofu {
+		binary = "tofu"
+		archive = fmt.Sprintf("tofu_%s_linux_amd64.zip", b.Version)
+		baseURL = fmt.Sprintf("https://github.com/opentofu/opentofu/releases/download/v%s", b.Version)
+		sums = fmt.Sprintf("tofu_%s_SHA256SUMS", b.Version)
+		link = " && \\\n    ln -s /usr/local/bin/tofu /usr/local/bin/terraform"
+	}
+
+	verify := fmt.Sprintf(`wget -q %s/%s && \
+    grep " %s$" %s | sha256sum -c - && \
+    rm %s`, baseURL, sums, archive, sums, sums)
+	if b.SHA256 != "" {
+		verify = fmt.Sprintf(`echo "%s  %s" | sha256sum -c -`, b.SHA256, archive)
+	}
+
+	return fmt.Sprintf(`
+RUN wget -q %s/%s && \
+    %s && \
+    unzip %s %s -d /usr/local/bin/ && \
+    rm %s%s
+`, baseURL, archive, verify, archive, binary, archive, link)
+}
@@ -0,0 +1,159 @@
+package container
+
+import (
+	"fmt"
+)
+
+// DefaultTerragruntVersion is installed when the Terragrunt runner requests no version.
+const DefaultTerragruntVersion = "0.58.0"
+
+// TerragruntBinary is the Terragrunt release installed in the image. Without SHA256 the binary
+// is verified against the SHA256SUMS file published with the release.
+type TerragruntBinary struct {
+	Version string
+	SHA256  string
+}
+
+// Resolved returns the binary with the default version filled in.
+func (b TerragruntBinary) Resolved() TerragruntBinary {
+	if b.Version == "" {
+		b.Version = DefaultTerragruntVersion
+	}
+	return b
+}
+
+// Validate checks the binary before its values are written into the Dockerfile.
+func (b TerragruntBinary) Validate() error {
+	b = b.Resolved()
+	if !versionPattern.MatchString(b.Version) {
+		return fmt.Errorf("invalid terragrunt version %q, expected a release version like %s", b.Version, DefaultTerragruntVersion)
+	}
+	if b.SHA256 != "" && !sha256Pattern.MatchString(b.SHA256) {
+		return fmt.Errorf("invalid terragrunt sha256 %q, expected 64 lowercase hex characters", b.SHA256)
+	}
+	return nil
+}
+
+// installStep returns the Dockerfile step downloading, verifying and installing Terragrunt.
+func (b TerragruntBinary) installStep() string {
+	b = b.Resolved()
+
+	baseURL := fmt.Sprintf("https://github.com/gruntwork-io/terragrunt/releases/download/v%s", b.Version)
+	verify := fmt.Sprintf(`wget -q %s/SHA256SUMS && \
+    grep " terragrunt_linux_amd64$" SHA256SUMS | sha256sum -c - && \
+    rm SHA256SUMS`, baseURL)
+	if b.SHA256 != "" {
+		verify = fmt.Sprintf(`echo "%s  terragrunt_linux_amd64" | sha256sum -c -`, b.SHA256)
+	}
+
+	return fmt.Sprintf(`
+RUN wget -q %s/terragrunt_linux_amd64 && \
+    %s && \
+    install -m 0755 terragrunt_linux_amd64 /usr/local/bin/terragrunt && \
+    rm terragrunt_linux_amd64
+`, baseURL, verify)
+}
+
+func (r Runner) terragruntCommand(script string) []string {
+	return []string{"/bin/bash", "-c", terragruntPrelude(r.RunAll) + script}
+}
+
+// terragruntPrelude enters the working directory and writes the units to run to $work/units,
+// relative to the working directory. A terragrunt.hcl in the working directory itself is the
+// shared configuration of the units when running all of them.
+func terragruntPrelude(runAll bool) string {
+	units := `echo . > "$work/units"`
+	runAllFlag := ""
+	if runAll {
+		units = `find . -name terragrunt.hcl -not -path '*/.terragrunt-cache/*' -not -path ./terragrunt.hcl -exec dirname {} \; | sed 's|^\./||' | sort > "$work/units"
+[ -s "$work/units" ] || { echo "no terragrunt.hcl found below ${WORKING_DIR:-.}" >&2; exit 1; }`
+		runAllFlag = "run-all "
+	}
+	return fmt.Sprintf(`set -o pipefail
+cd "${WORKING_DIR:-.}" || exit 1
+export TERRAGRUNT_NON_INTERACTIVE=true
+run_all=%q
+work=$(mktemp -d)
+%s
+`, runAllFlag, units)
+}
+
+// terragruntSavedApplyScript applies the saved plans of terragruntPlanScript and prints the
+// outputs as JSON on the last line, the values of sensitive outputs redacted, keyed by unit when
+// running all units.
+func terragruntSavedApplyScript(runAll bool) string {
+	script := `terragrunt ${run_all}apply -input=false controller.tfplan 1>&2 || exit 1
+outputs="$work/outputs.json"
+`
+	if !runAll {
+		return script + `terragrunt output -json 2>/dev/null > "$outputs" || exit 1
+` + printOutputsScript(false)
+	}
+	return script + `echo '{}' > "$outputs"
+while IFS= read -r unit; do
+  out=$(cd "$unit" && terragrunt output -json 2>/dev/null) || exit 1
+  jq -c --arg unit "$unit" --argjson out "$out" '. + {($unit): $out}' "$outputs" > "$work/outputs.next" || exit 1
+  mv "$work/outputs.next" "$outputs"
+done < "$work/units"
+` + printOutputsScript(true)
+}
+
+const terragruntDestroyScript = `terragrunt ${run_all}destroy -input=false -auto-approve 1>&2 || exit 1
+echo '{"destroyed": true}'
+`
+
+// terragruntPlanScript plans every unit in dependency order, saving the plan of each unit in its
+// Terraform working directory, then prints the plans as text between markers and as a single
+// plan_summary JSON plan whose resource and output addresses are prefixed with their unit.
+const terragruntPlanScript = `terragrunt ${run_all}plan -input=false -lock=false -out=controller.tfplan 1>&2 || exit 1
+echo '` + planTextBegin + `'
+while IFS= read -r unit; do
+  [ -n "$run_all" ] && echo "# $unit"
+  (cd "$unit" && terragrunt show -no-color controller.tfplan 2>/dev/null) || exit 1
+done < "$work/units"
+echo '` + planTextEnd + `'
+i=0
+while IFS= read -r unit; do
+  plan=$(cd "$unit" && terragrunt show -json -no-color controller.tfplan 2>/dev/null) || exit 1
+  jq -c --arg unit "$unit" '` + planSummaryJq + `plan_summary |
+    if $unit == "." then . else
+      .resource_changes = ((.resource_changes // []) | map(.address = $unit + "/" + .address))
+      | .output_changes = ((.output_changes // {}) | with_entries(.key = $unit + "/" + .key))
+    end' <<<"$plan" > "$work/plan-$i.json" || exit 1
+  i=$((i + 1))
+done < "$work/units"
+jq -cs '{
+  format_version: .[0].format_version,
+  terraform_version: .[0].terraform_version,
+  resource_changes: (map(.resource_changes // []) | add),
+  output_changes: (map(.output_changes // {}) | add)
+}' "$work"/plan-*.json || exit 1
+`
+
+// terragruntShowStateScript prints the state summaries of the units as one summary whose child
+// modules are the units.
+const terragruntShowStateScript = `i=0
+while IFS= read -r unit; do
+  state=$(cd "$unit" && terragrunt show -json -no-color 2>/dev/null) || exit 1
+  jq -c --arg unit "$unit" '` + stateSummaryJq + `state_summary | {
+    terraform_version,
+    values: {
+      outputs: ((.values.outputs // {}) | if $unit == "." then . else with_entries(.key = $unit + "/" + .key) end),
+      root_module: ((.values.root_module // {}) + {address: (if $unit == "." then "" else $unit end)})
+    }
+  }' <<<"$state" > "$work/state-$i.json" || exit 1
+  i=$((i + 1))
+done < "$work/units"
+jq -cs '{
+  terraform_version: .[0].terraform_version,
+  values: {outputs: (map(.values.outputs) | add), root_module: {child_modules: map(.values.root_module)}}
+}' "$work"/state-*.json || exit 1
+`
+
+const terragruntStateCountScript = `count=0
+while IFS= read -r unit; do
+  resources=$(cd "$unit" && terragrunt state list 2>/dev/null) || exit 1
+  count=$((count + $(printf '%s' "$resources" | grep -c . || true)))
+done < "$work/units"
+echo "{\"stateResources\": $count}"
+`
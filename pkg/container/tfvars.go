@@ -0,0 +1,193 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// tfvarsFileName sorts after the var files so inline variables take precedence
+	tfvarsFileName  = "controller-zz.auto.tfvars.json"
+	tfvarsMountPath = "/etc/terraform-controller/tfvars"
+	tfvarsVolume    = "tfvars"
+)
+
+// ApplyTfvarsConfigMap creates or updates the ConfigMap holding the rendered variables of a resource.
+func ApplyTfvarsConfigMap(clientset *kubernetes.Clientset, name, namespace, content string) (string, error) {
+	configMapName := fmt.Sprintf("%s-tfvars", name)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName,
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			tfvarsFileName: content,
+		},
+	}
+
+	existing, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = clientset.CoreV1().ConfigMaps(namespace).Create(context.Background(), configMap, metav1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to create tfvars ConfigMap: %v", err)
+		}
+		log.Printf("Created ConfigMap: %s", configMapName)
+		return configMapName, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get tfvars ConfigMap: %v", err)
+	}
+
+	existing.Data = configMap.Data
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Update(context.Background(), existing, metav1.UpdateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to update tfvars ConfigMap: %v", err)
+	}
+	return configMapName, nil
+}
+
+// TfvarsInputs returns the run pod inputs placing the var files of the repository and the
+// rendered variables ConfigMap, if any, as auto-loaded tfvars files in the Terraform working directory.
+// Auto-loaded files are read in lexical order, so later var files override earlier ones.
+func TfvarsInputs(configMapName string, varFiles []string) RunPodInputs {
+	var inputs RunPodInputs
+	if configMapName == "" && len(varFiles) == 0 {
+		return inputs
+	}
+
+	setup := []string{`tfvars_dir="${WORKING_DIR:-.}"`}
+	for i, varFile := range varFiles {
+		target := fmt.Sprintf("controller-%02d-%s", i, autoTfvarsName(path.Base(varFile)))
+		setup = append(setup, fmt.Sprintf(`cp %s "$tfvars_dir/"%s || { echo %s >&2; exit 1; }`,
+			shellQuote(varFile), shellQuote(target), shellQuote("var file "+varFile+" not found")))
+	}
+
+	if configMapName != "" {
+		inputs.Volumes = []corev1.Volume{
+			{
+				Name: tfvarsVolume,
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+					},
+				},
+			},
+		}
+		inputs.VolumeMounts = []corev1.VolumeMount{
+			{
+				Name:      tfvarsVolume,
+				MountPath: tfvarsMountPath,
+				ReadOnly:  true,
+			},
+		}
+		setup = append(setup, fmt.Sprintf(`cp %s "$tfvars_dir/" || exit 1`, path.Join(tfvarsMountPath, tfvarsFileName)))
+	}
+
+	inputs.Setup = strings.Join(setup, "\n")
+	return inputs
+}
+
+// autoTfvarsName makes Terraform auto-load a var file, keeping its JSON syntax if it has one.
+func autoTfvarsName(base string) string {
+	if strings.HasSuffix(base, ".json") {
+		return strings.TrimSuffix(strings.TrimSuffix(base, ".json"), ".tfvars") + ".auto.tfvars.json"
+	}
+	return strings.TrimSuffix(base, ".tfvars") + ".auto.tfvars"
+}
+
+// shellQuote quotes a value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+const (
+	sopsMountPath = "/etc/terraform-controller/sops"
+	sopsVolume    = "sops-tfvars"
+)
+
+// sopsOfLabel marks the Secrets holding the decrypted SOPS files of a resource.
+const sopsOfLabel = "alustan.io/sops-of"
+
+// CreateSopsSecret creates a Secret holding the decrypted SOPS files for one run pod of a
+// resource and returns its name. It is owned by the resource so it is removed with it, but
+// callers delete it with DeleteInputSecrets as soon as the run pod finished.
+func CreateSopsSecret(clientset *kubernetes.Clientset, name, namespace string, owner metav1.OwnerReference, files map[string][]byte) (string, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName:    fmt.Sprintf("%s-sops-tfvars-", name),
+			Namespace:       namespace,
+			Labels:          map[string]string{sopsOfLabel: name},
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: files,
+	}
+
+	created, err := clientset.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to create sops Secret: %v", err)
+	}
+	log.Printf("Created Secret: %s", created.Name)
+	return created.Name, nil
+}
+
+// DeleteSopsSecrets deletes all Secrets holding decrypted SOPS files of a resource, if any.
+func DeleteSopsSecrets(clientset *kubernetes.Clientset, name, namespace string) error {
+	err := clientset.CoreV1().Secrets(namespace).DeleteCollection(context.Background(), metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", sopsOfLabel, name),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete sops Secrets: %v", err)
+	}
+	return nil
+}
+
+// DeleteInputSecrets deletes the Secrets created for the inputs of a run pod once it finished.
+func DeleteInputSecrets(clientset *kubernetes.Clientset, namespace string, inputs RunPodInputs) error {
+	for _, secretName := range inputs.Secrets {
+		err := clientset.CoreV1().Secrets(namespace).Delete(context.Background(), secretName, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete Secret %s: %v", secretName, err)
+		}
+		log.Printf("Deleted Secret: %s", secretName)
+	}
+	return nil
+}
+
+// SopsInputs returns the run pod inputs mounting the decrypted SOPS files from their Secret,
+// which the kubelet keeps on tmpfs, and linking them as auto-loaded tfvars files into the
+// Terraform working directory so the plaintext is never written to disk.
+func SopsInputs(secretName string, fileNames []string) RunPodInputs {
+	setup := []string{`tfvars_dir="${WORKING_DIR:-.}"`}
+	for _, fileName := range fileNames {
+		setup = append(setup, fmt.Sprintf(`ln -sf %s "$tfvars_dir/"%s || exit 1`, shellQuote(path.Join(sopsMountPath, fileName)), shellQuote(fileName)))
+	}
+
+	return RunPodInputs{
+		Volumes: []corev1.Volume{
+			{
+				Name: sopsVolume,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+				},
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      sopsVolume,
+				MountPath: sopsMountPath,
+				ReadOnly:  true,
+			},
+		},
+		Setup:   strings.Join(setup, "\n"),
+		Secrets: []string{secretName},
+	}
+}
@@ -0,0 +1,15 @@
+package container
+
+// stateScript counts the resources left in the Terraform state. Terraform output goes to
+// stderr so the last log line is the JSON result read by WaitForPodCompletion.
+const stateScript = `cd "${WORKING_DIR:-.}" || exit 1
+terraform init -input=false 1>&2 || exit 1
+resources=$(terraform state list) || exit 1
+count=$(printf '%s' "$resources" | grep -c . || true)
+echo "{\"stateResources\": $count}"
+`
+
+// StateCountCommand returns the run pod command used to verify that a destroy left no resources in the state.
+func StateCountCommand() []string {
+	return []string{"/bin/bash", "-c", stateScript}
+}
@@ -0,0 +1,19 @@
+package container
+
+import (
+	"fmt"
+)
+
+// WorkspaceInputs returns the run pod inputs selecting the Terraform workspace, creating it if
+// needed, before the script or command runs. It is created with workspace new when it cannot be
+// selected, as select -or-create needs Terraform 1.4 or OpenTofu 1.6. TF_WORKSPACE keeps it
+// selected if the script initializes the working directory again.
+func WorkspaceInputs(workspace string) RunPodInputs {
+	if workspace == "" {
+		return RunPodInputs{}
+	}
+	return RunPodInputs{
+		Setup: fmt.Sprintf(`(cd "${WORKING_DIR:-.}" && terraform init -input=false 1>&2 && { terraform workspace select %[1]s 1>&2 || terraform workspace new %[1]s 1>&2; }) || exit 1
+export TF_WORKSPACE=%[1]s`, shellQuote(workspace)),
+	}
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DeletionPolicyDestroy runs the destroy script before the resource is removed (default)
+	DeletionPolicyDestroy = "Destroy"
+	// DeletionPolicyOrphan removes the resource and leaves the infrastructure in place
+	DeletionPolicyOrphan = "Orphan"
+	// DeletionPolicyProtect refuses the deletion of the resource
+	DeletionPolicyProtect = "Protect"
+
+	// confirmDestroyAnnotation must name the resource before it can be destroyed when
+	// spec.requireDestroyConfirmation is set
+	confirmDestroyAnnotation = "alustan.io/confirm-destroy"
+)
+
+// HandleValidateDelete is the validating admission webhook refusing the deletion of protected
+// resources and of resources whose destroy was not confirmed.
+func (c *Controller) HandleValidateDelete(r *gin.Context) {
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(r.Request.Body).Decode(&review); err != nil {
+		r.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if review.Request == nil {
+		r.String(http.StatusBadRequest, "admission review has no request")
+		return
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+
+	if review.Request.Operation == admissionv1.Delete {
+		var parent ParentResource
+		if err := json.Unmarshal(review.Request.OldObject.Raw, &parent); err != nil {
+			r.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if reason := deletionRefusal(parent); reason != "" {
+			log.Printf("Refusing deletion of %s/%s: %s", parent.Metadata.Namespace, parent.Metadata.Name, reason)
+			response.Allowed = false
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Message: reason,
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+			}
+		}
+	}
+
+	review.Response = response
+	review.Request = nil
+	r.JSON(http.StatusOK, review)
+}
+
+// deletionRefusal returns why the resource may not be deleted, or an empty string if it may.
+func deletionRefusal(parent ParentResource) string {
+	switch parent.Spec.DeletionPolicy {
+	case DeletionPolicyProtect:
+		return fmt.Sprintf("%s is protected by deletionPolicy %s, change it to %s or %s to delete it", parent.Metadata.Name, DeletionPolicyProtect, DeletionPolicyDestroy, DeletionPolicyOrphan)
+	case DeletionPolicyOrphan:
+		return ""
+	}
+
+	if parent.Spec.RequireDestroyConfirmation && parent.Metadata.Annotations[confirmDestroyAnnotation] != parent.Metadata.Name {
+		return fmt.Sprintf("destroying %s requires the annotation %s=%s", parent.Metadata.Name, confirmDestroyAnnotation, parent.Metadata.Name)
+	}
+	return ""
+}
+
+// checkDeletionPolicy returns the finalize response when the deletion policy means the destroy
+// must not run, or nil when it should. Orphaned resources are released without a destroy, and
+// refused deletions keep the finalizer until the policy or confirmation allows them.
+func (c *Controller) checkDeletionPolicy(observed SyncRequest) map[string]interface{} {
+	if observed.Parent.Spec.DeletionPolicy == DeletionPolicyOrphan {
+		log.Printf("Orphaning infrastructure of %s", observed.Parent.Metadata.Name)
+		return map[string]interface{}{
+			"state":     "Orphaned",
+			"message":   "Resource deleted without destroying its infrastructure",
+			"finalized": true,
+		}
+	}
+
+	if reason := deletionRefusal(observed.Parent); reason != "" {
+		status := map[string]interface{}{
+			"state":   "DeletionBlocked",
+			"message": reason,
+		}
+		c.updateStatus(observed, status)
+		return status
+	}
+	return nil
+}
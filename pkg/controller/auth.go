@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// authorize authenticates the bearer token of an API request with a TokenReview and checks with
+// a SubjectAccessReview that its user may perform verb on the Terraform resource, so the API
+// grants exactly what the Kubernetes RBAC of the caller grants. It returns the verified
+// username, or writes the error response and returns false.
+func (c *Controller) authorize(r *gin.Context, verb, namespace, name string) (string, bool) {
+	token, found := strings.CutPrefix(r.GetHeader("Authorization"), "Bearer ")
+	if !found || token == "" {
+		r.JSON(http.StatusUnauthorized, gin.H{"error": "a bearer token is required"})
+		return "", false
+	}
+
+	review, err := c.clientset.AuthenticationV1().TokenReviews().Create(context.Background(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.Printf("Error reviewing token: %v", err)
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate the request"})
+		return "", false
+	}
+	if !review.Status.Authenticated {
+		r.JSON(http.StatusUnauthorized, gin.H{"error": "invalid bearer token"})
+		return "", false
+	}
+	user := review.Status.User
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	access, err := c.clientset.AuthorizationV1().SubjectAccessReviews().Create(context.Background(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     terraformGVR.Group,
+				Resource:  terraformGVR.Resource,
+				Name:      name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.Printf("Error reviewing access of %s: %v", user.Username, err)
+		r.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authorize the request"})
+		return "", false
+	}
+	if !access.Status.Allowed {
+		r.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s cannot %s terraform %s/%s", user.Username, verb, namespace, name)})
+		return "", false
+	}
+	return user.Username, true
+}
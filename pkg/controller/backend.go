@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/terraform"
+)
+
+// Backend configures the remote state backend of a resource. The state key defaults to
+// <keyPrefix>/<namespace>/<name> so two resources never share state by accident.
+// Credentials are read from the environment variables the backend supports, e.g.
+// AWS_ACCESS_KEY_ID, ARM_CLIENT_SECRET or GOOGLE_CREDENTIALS, set from the keys of the credentials Secret.
+type Backend struct {
+	Type                 string            `json:"type"`
+	Bucket               string            `json:"bucket,omitempty"`
+	Container            string            `json:"container,omitempty"`
+	StorageAccount       string            `json:"storageAccount,omitempty"`
+	ResourceGroup        string            `json:"resourceGroup,omitempty"`
+	Key                  string            `json:"key,omitempty"`
+	KeyPrefix            string            `json:"keyPrefix,omitempty"`
+	LockTable            string            `json:"lockTable,omitempty"`
+	Region               string            `json:"region,omitempty"`
+	Config               map[string]string `json:"config,omitempty"`
+	CredentialsSecretRef *SecretReference  `json:"credentialsSecretRef,omitempty"`
+}
+
+// backendInputs returns the run pod inputs configuring the backend of a resource, if set.
+func backendInputs(parent ParentResource) (container.RunPodInputs, error) {
+	backend := parent.Spec.Backend
+	if backend == nil {
+		return container.RunPodInputs{}, nil
+	}
+
+	settings, err := backendSettings(parent)
+	if err != nil {
+		return container.RunPodInputs{}, err
+	}
+
+	var credentialsSecretName string
+	if backend.CredentialsSecretRef != nil {
+		credentialsSecretName = backend.CredentialsSecretRef.Name
+	}
+	override, err := terraform.RenderBackendOverride(backend.Type, settings)
+	if err != nil {
+		return container.RunPodInputs{}, err
+	}
+	return container.BackendInputs(override, credentialsSecretName), nil
+}
+
+// backendSettings maps the backend spec to the settings of the Terraform backend type.
+func backendSettings(parent ParentResource) (map[string]interface{}, error) {
+	backend := parent.Spec.Backend
+	settings := make(map[string]interface{})
+	set := func(name, value string) {
+		if value != "" {
+			settings[name] = value
+		}
+	}
+
+	switch backend.Type {
+	case "s3":
+		if backend.Bucket == "" {
+			return nil, fmt.Errorf("backend s3 requires a bucket")
+		}
+		set("bucket", backend.Bucket)
+		set("key", stateKey(parent))
+		set("region", backend.Region)
+		set("dynamodb_table", backend.LockTable)
+		settings["encrypt"] = true
+	case "gcs":
+		if backend.Bucket == "" {
+			return nil, fmt.Errorf("backend gcs requires a bucket")
+		}
+		set("bucket", backend.Bucket)
+		set("prefix", stateKey(parent))
+	case "azurerm":
+		if backend.StorageAccount == "" || backend.Container == "" {
+			return nil, fmt.Errorf("backend azurerm requires a storageAccount and a container")
+		}
+		set("storage_account_name", backend.StorageAccount)
+		set("container_name", backend.Container)
+		set("resource_group_name", backend.ResourceGroup)
+		set("key", stateKey(parent))
+	default:
+		return nil, fmt.Errorf("unsupported backend type %q, expected s3, gcs or azurerm", backend.Type)
+	}
+
+	for name, value := range backend.Config {
+		settings[name] = value
+	}
+	return settings, nil
+}
+
+// stateKey returns the state key of a resource, unique to it unless set explicitly.
+func stateKey(parent ParentResource) string {
+	if parent.Spec.Backend.Key != "" {
+		return parent.Spec.Backend.Key
+	}
+	return path.Join(parent.Spec.Backend.KeyPrefix, parent.Metadata.Namespace, parent.Metadata.Name)
+}
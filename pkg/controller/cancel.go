@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/kubernetes"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// cancelRunAnnotation cancels the in-flight run whenever its value changes
+	cancelRunAnnotation = "alustan.io/cancel-run"
+
+	// cancelGracePeriod is how long Terraform gets to exit after SIGINT before its pod is deleted
+	cancelGracePeriod = 2 * time.Minute
+)
+
+// HandleCancel cancels the in-flight run of a Terraform resource. The caller needs the patch
+// permission on the resource, the same as for the cancel annotation, and is recorded by the
+// username of its verified token.
+func (c *Controller) HandleCancel(r *gin.Context) {
+	namespace := r.Param("namespace")
+	name := r.Param("name")
+
+	requestedBy, ok := c.authorize(r, "patch", namespace, name)
+	if !ok {
+		return
+	}
+
+	go c.cancelRun(namespace, name, requestedBy)
+
+	r.JSON(http.StatusAccepted, gin.H{"cancelling": fmt.Sprintf("%s/%s", namespace, name), "requestedBy": requestedBy})
+}
+
+// handleCancelAnnotation starts a cancellation if the cancel annotation changed since it was last handled.
+func (c *Controller) handleCancelAnnotation(observed SyncRequest) {
+	requested := observed.Parent.Metadata.Annotations[cancelRunAnnotation]
+	if requested == "" || requested == statusString(observed.Parent.Status, "lastHandledCancelRun") {
+		return
+	}
+
+	namespace := observed.Parent.Metadata.Namespace
+	name := observed.Parent.Metadata.Name
+	err := kubernetes.MergeStatus(c.dynClient, namespace, name, map[string]interface{}{
+		"lastHandledCancelRun": requested,
+	})
+	if err != nil {
+		log.Printf("Error recording cancellation of %s/%s: %v", namespace, name, err)
+		return
+	}
+
+	// Annotations do not tell who set them, so the cancellation is recorded by its annotation only
+	requestedBy := fmt.Sprintf("annotation %s=%s", cancelRunAnnotation, requested)
+	go c.cancelRun(namespace, name, requestedBy)
+}
+
+// cancelRun interrupts the in-flight run of a resource and marks it cancelled, so the
+// goroutine waiting for the run reports it as Cancelled instead of Failed.
+func (c *Controller) cancelRun(namespace, name, requestedBy string) {
+	key := fmt.Sprintf("%s/%s", namespace, name)
+	log.Printf("Cancelling run of %s requested by %s", key, requestedBy)
+
+	c.cancelMu.Lock()
+	c.cancellations[key] = requestedBy
+	c.cancelMu.Unlock()
+
+	pods, err := container.CancelRun(c.clientset, c.restConfig, name, namespace, cancelGracePeriod)
+	if err != nil {
+		log.Printf("Error cancelling run of %s: %v", key, err)
+	}
+	if len(pods) == 0 {
+		log.Printf("No run in progress for %s", key)
+		c.takeCancellation(namespace, name)
+		return
+	}
+
+	err = kubernetes.MergeStatus(c.dynClient, namespace, name, map[string]interface{}{
+		"conditions": []interface{}{cancelledCondition(requestedBy)},
+	})
+	if err != nil {
+		log.Printf("Error recording cancellation of %s: %v", key, err)
+	}
+}
+
+// takeCancellation returns who cancelled the run of a resource, if it was cancelled, and clears the request.
+func (c *Controller) takeCancellation(namespace, name string) (string, bool) {
+	key := fmt.Sprintf("%s/%s", namespace, name)
+
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+
+	requestedBy, cancelled := c.cancellations[key]
+	delete(c.cancellations, key)
+	return requestedBy, cancelled
+}
+
+// cancelledStatus is the status of a run stopped by a cancellation request.
+func cancelledStatus(requestedBy string) map[string]interface{} {
+	return map[string]interface{}{
+		"state":      "Cancelled",
+		"message":    fmt.Sprintf("Run cancelled by %s", requestedBy),
+		"conditions": []interface{}{cancelledCondition(requestedBy)},
+	}
+}
+
+func cancelledCondition(requestedBy string) map[string]interface{} {
+	return kubernetes.NewCondition("Cancelled", "True", "CancelRequested", fmt.Sprintf("Run cancelled by %s", requestedBy))
+}
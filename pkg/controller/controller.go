@@ -20,39 +20,91 @@ import (
     corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	dynclient "k8s.io/client-go/dynamic"
 	k8sclient "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
 	maxRetries = 5
+	workers    = 5
 )
 
+var terraformGVR = schema.GroupVersionResource{
+	Group:    "alustan.io",
+	Version:  "v1alpha1",
+	Resource: "terraforms",
+}
+
 type Controller struct {
-	clientset   *k8sclient.Clientset
-	dynClient   dynclient.Interface
-	syncInterval time.Duration
+	clientset        *k8sclient.Clientset
+	dynClient        dynclient.Interface
+	syncInterval     time.Duration
+	pollInterval     time.Duration
+	restConfig       *rest.Config
+	queue            workqueue.RateLimitingInterface
+	webhookSecrets   map[string]string
+	cancelMu         sync.Mutex
+	cancellations    map[string]string
+	stateMu          sync.Mutex
+	stateInspections map[string]*stateInspection
+	runsMu           sync.Mutex
+	runs             map[string]bool
+	headsMu          sync.Mutex
+	remoteHeads      map[string]remoteHeads
 }
 
 type TerraformConfigSpec struct {
-	Provider           string            `json:"provider"`
-	Variables          map[string]string `json:"variables"`
-	Scripts            Scripts           `json:"scripts"`
-	GitRepo            GitRepo           `json:"gitRepo"`
-	ContainerRegistry  ContainerRegistry `json:"containerRegistry"`
+	Provider                   string                 `json:"provider"`
+	Variables                  map[string]string      `json:"variables"`
+	VariablesFrom              []VariablesFrom        `json:"variablesFrom,omitempty"`
+	Vars                       map[string]interface{} `json:"vars,omitempty"`
+	VarFiles                   []string               `json:"varFiles,omitempty"`
+	Sops                       *Sops                  `json:"sops,omitempty"`
+	Backend                    *Backend               `json:"backend,omitempty"`
+	Workspace                  string                 `json:"workspace,omitempty"`
+	Terraform                  *Terraform             `json:"terraform,omitempty"`
+	Runner                     string                 `json:"runner,omitempty"`
+	Terragrunt                 *Terragrunt            `json:"terragrunt,omitempty"`
+	Hooks                      Hooks                  `json:"hooks,omitempty"`
+	Imports                    []Import               `json:"imports,omitempty"`
+	GenerateImportConfig       bool                   `json:"generateImportConfig,omitempty"`
+	Scripts                    Scripts                `json:"scripts"`
+	GitRepo                    GitRepo                `json:"gitRepo"`
+	ContainerRegistry          ContainerRegistry      `json:"containerRegistry"`
+	WorkingDir                 string                 `json:"workingDir,omitempty"`
+	SyncInterval               string                 `json:"syncInterval,omitempty"`
+	Schedule                   Schedule               `json:"schedule,omitempty"`
+	ChangeWindows              []ChangeWindow         `json:"changeWindows,omitempty"`
+	Suspend                    bool                   `json:"suspend,omitempty"`
+	DeletionPolicy             string                 `json:"deletionPolicy,omitempty"`
+	RequireDestroyConfirmation bool                   `json:"requireDestroyConfirmation,omitempty"`
+	VerifyDestroy              bool                   `json:"verifyDestroy,omitempty"`
+	DependsOn                  []Dependency           `json:"dependsOn,omitempty"`
+	Policy                     *Policy                `json:"policy,omitempty"`
+	DestructiveChangePolicy    string                 `json:"destructiveChangePolicy,omitempty"`
+	DestructiveResourceTypes   []string               `json:"destructiveResourceTypes,omitempty"`
 }
 
+// Schedule holds cron expressions for periodic applies and plan-only drift checks.
+type Schedule struct {
+	Apply      string `json:"apply,omitempty"`
+	DriftCheck string `json:"driftCheck,omitempty"`
+	TimeZone   string `json:"timeZone,omitempty"`
+}
+
+// Scripts are run by the script runner.
 type Scripts struct {
 	Deploy  string `json:"deploy"`
 	Destroy string `json:"destroy"`
 }
 
 type GitRepo struct {
-	URL    string `json:"url"`
-	Branch string `json:"branch"`
+	URL    string   `json:"url"`
+	Branch string   `json:"branch"`
+	Paths  []string `json:"paths,omitempty"`
 }
 
 type ContainerRegistry struct {
@@ -63,7 +115,8 @@ type ParentResource struct {
 	ApiVersion string              `json:"apiVersion"`
 	Kind       string              `json:"kind"`
 	Metadata   metav1.ObjectMeta   `json:"metadata"`
-	Spec       TerraformConfigSpec `json:"spec"`
+	Spec       TerraformConfigSpec    `json:"spec"`
+	Status     map[string]interface{} `json:"status,omitempty"`
 }
 
 type SyncRequest struct {
@@ -71,15 +124,23 @@ type SyncRequest struct {
 	Finalizing bool           `json:"finalizing"`
 }
 
-func NewController(clientset *k8sclient.Clientset, dynClient dynclient.Interface, syncInterval time.Duration) *Controller {
+func NewController(clientset *k8sclient.Clientset, dynClient dynclient.Interface, restConfig *rest.Config, syncInterval, pollInterval time.Duration) *Controller {
 	return &Controller{
-		clientset:    clientset,
-		dynClient:    dynClient,
-		syncInterval: syncInterval,
+		clientset:        clientset,
+		dynClient:        dynClient,
+		restConfig:       restConfig,
+		syncInterval:     syncInterval,
+		pollInterval:     pollInterval,
+		queue:            workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		webhookSecrets:   util.GetWebhookSecrets(),
+		cancellations:    make(map[string]string),
+		stateInspections: make(map[string]*stateInspection),
+		runs:             make(map[string]bool),
+		remoteHeads:      make(map[string]remoteHeads),
 	}
 }
 
-func NewInClusterController(syncInterval time.Duration) *Controller {
+func NewInClusterController(syncInterval, pollInterval time.Duration) *Controller {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Fatalf("Error creating in-cluster config: %v", err)
@@ -95,7 +156,7 @@ func NewInClusterController(syncInterval time.Duration) *Controller {
 		log.Fatalf("Error creating dynamic Kubernetes client: %v", err)
 	}
 
-	return NewController(clientset, dynClient, syncInterval)
+	return NewController(clientset, dynClient, config, syncInterval, pollInterval)
 }
 
 func (c *Controller) ServeHTTP(r *gin.Context) {
@@ -111,21 +172,167 @@ func (c *Controller) ServeHTTP(r *gin.Context) {
 		}
 	}()
 
-	response := c.handleSyncRequest(observed)
+	c.handleCancelAnnotation(observed)
+
+	// Skip the build entirely when neither the spec nor the remote commit changed and no scheduled apply is due
+	var commit string
+	if !observed.Finalizing && !observed.Parent.Spec.Suspend {
+		commit = c.remoteHead(observed)
+		if reason := c.runReason(observed, commit, time.Now()); reason == "" {
+			log.Printf("No changes for %s, returning current status", observed.Parent.Metadata.Name)
+			r.JSON(http.StatusOK, gin.H{"body": observed.Parent.Status})
+			return
+		}
+	}
+
+	response := c.handleSyncRequest(observed, commit)
 
 	r.Writer.Header().Set("Content-Type", "application/json")
 	r.JSON(http.StatusOK, gin.H{"body": response})
 }
 
-func (c *Controller) handleSyncRequest(observed SyncRequest) map[string]interface{} {
-	envVars := c.extractEnvVars(observed.Parent.Spec.Variables)
+// handleSyncRequest runs the resource at the given commit of the tracked branch, which is empty
+// if it could not be determined.
+func (c *Controller) handleSyncRequest(observed SyncRequest, commit string) map[string]interface{} {
+	envVars := c.runEnvVars(observed.Parent.Spec)
 	secretName := fmt.Sprintf("%s-container-secret", observed.Parent.Metadata.Name)
-	log.Printf("Observed Parent Spec: %+v", observed.Parent.Spec)
+	log.Printf("Observed Terraform resource %s/%s at generation %d", observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name, observed.Parent.Metadata.Generation)
+	now := time.Now()
+
+	if observed.Parent.Spec.Suspend {
+		return c.suspendedStatus(observed)
+	}
+
+	if observed.Finalizing {
+		if response := c.checkDeletionPolicy(observed); response != nil {
+			return response
+		}
+		// Destroys run in reverse dependency order
+		if response := c.checkDependents(observed); response != nil {
+			return response
+		}
+		if response := c.checkDestroyBackoff(observed, now); response != nil {
+			return response
+		}
+	}
+
+	// Applies wait for their dependencies and receive their outputs, destroys only receive the outputs
+	var dependencies dependencyOutputs
+	if !observed.Finalizing {
+		var waiting map[string]interface{}
+		dependencies, waiting = c.checkDependencies(observed)
+		if waiting != nil {
+			c.updateStatus(observed, waiting)
+			return waiting
+		}
+	} else {
+		var err error
+		dependencies, err = c.dependencyEnvVars(observed)
+		if err != nil {
+			status := c.errorResponse("reading dependency outputs", err)
+			c.updateStatus(observed, status)
+			return status
+		}
+	}
+	envVars = mergeEnvVars(envVars, dependencies.EnvVars)
+
+	if err := validateRunner(observed.Parent.Spec); err != nil {
+		status := c.errorResponse("validating runner", err)
+		c.updateStatus(observed, status)
+		return status
+	}
+	if err := validateWorkingDir(observed.Parent.Spec.WorkingDir); err != nil {
+		status := c.errorResponse("validating working directory", err)
+		c.updateStatus(observed, status)
+		return status
+	}
+	if err := validateHooks(observed.Parent.Spec.Hooks); err != nil {
+		status := c.errorResponse("validating hooks", err)
+		c.updateStatus(observed, status)
+		return status
+	}
+	if err := validateImports(observed.Parent.Spec); err != nil {
+		status := c.errorResponse("validating imports", err)
+		c.updateStatus(observed, status)
+		return status
+	}
+	if err := c.checkVariableSources(observed.Parent.Metadata.Namespace, observed.Parent.Spec); err != nil {
+		status := c.errorResponse("reading variables", err)
+		c.updateStatus(observed, status)
+		return status
+	}
+	// Applies build the image at the polled commit, destroys use the image of the last build
+	sourceCommit := commit
+	if observed.Finalizing {
+		sourceCommit = builtCommit(observed)
+	}
+	inputs, err := c.runPodInputs(observed, sourceCommit, dependencies.Sensitive)
+	if err != nil {
+		status := c.errorResponse("rendering variables", err)
+		c.updateStatus(observed, status)
+		return status
+	}
+	defer c.releaseRunPodInputs(observed.Parent.Metadata.Namespace, inputs)
+
+	// Applies and destroys only run inside the change windows and outside of freezes
+	if deferred := c.checkChangeWindow(observed, now); deferred != nil {
+		c.updateStatus(observed, deferred)
+		return deferred
+	}
 
 	// Initial status update: processing started
 	initialStatus := map[string]interface{}{
-		"state":   "Progressing",
-		"message": "Starting processing",
+		"state":              "Progressing",
+		"message":            "Starting processing",
+		"observedGeneration": observed.Parent.Metadata.Generation,
+		"lastRunTime":        now.UTC().Format(time.RFC3339),
+	}
+	if observed.Parent.Spec.Backend != nil {
+		initialStatus["stateKey"] = stateKey(observed.Parent)
+	}
+	if observed.Parent.Spec.Workspace != "" {
+		initialStatus["workspace"] = observed.Parent.Spec.Workspace
+	}
+	// Hook results are reported per run
+	if _, found := observed.Parent.Status["hooks"]; found {
+		initialStatus["hooks"] = []interface{}{}
+	}
+	if _, found := observed.Parent.Status["imports"]; found && len(observed.Parent.Spec.Imports) == 0 {
+		initialStatus["imports"] = []interface{}{}
+	}
+
+	if !observed.Finalizing {
+		if commit != "" {
+			initialStatus["lastAttemptedCommit"] = commit
+		}
+		if requestedAt := observed.Parent.Metadata.Annotations[reconcileAtAnnotation]; requestedAt != "" {
+			initialStatus["lastHandledReconcileAt"] = requestedAt
+		}
+		binary := terraformBinary(observed.Parent.Spec)
+		initialStatus["terraform"] = map[string]interface{}{
+			"distribution": binary.Distribution,
+			"version":      binary.Version,
+		}
+		var conditions []interface{}
+		if kubernetes.HasCondition(observed.Parent.Status, "Cancelled", "True") {
+			conditions = append(conditions, kubernetes.NewCondition("Cancelled", "False", "RunStarted", "A new run started"))
+		}
+		if len(observed.Parent.Spec.DependsOn) > 0 {
+			conditions = append(conditions, kubernetes.NewCondition("DependenciesReady", "True", "DependenciesReady", "All dependencies are ready"))
+		}
+		if len(conditions) > 0 {
+			initialStatus["conditions"] = conditions
+		}
+
+		nextRunTimes, err := c.nextRunTimes(observed, now)
+		if err != nil {
+			status := c.errorResponse("parsing schedule", err)
+			c.updateStatus(observed, status)
+			return status
+		}
+		for field, value := range nextRunTimes {
+			initialStatus[field] = value
+		}
 	}
 	c.updateStatus(observed, initialStatus)
 
@@ -137,7 +344,8 @@ func (c *Controller) handleSyncRequest(observed SyncRequest) map[string]interfac
 		scriptContent = observed.Parent.Spec.Scripts.Deploy
 	}
 
-	if scriptContent == "" {
+	// Only the script runner runs the scripts, the other runners run their own commands
+	if scriptContent == "" && runnerName(observed.Parent.Spec) == RunnerScript {
 		status := c.errorResponse("executing script", fmt.Errorf("script is missing"))
 		c.updateStatus(observed, status)
 		return status
@@ -158,14 +366,14 @@ func (c *Controller) handleSyncRequest(observed SyncRequest) map[string]interfac
 		repoDir := filepath.Join("/workspace", "tmp", observed.Parent.Metadata.Name)
 		sshKey := os.Getenv("GIT_SSH_SECRET")
 
-		dockerfileAdditions, providerExists, err := c.setupProvider(observed.Parent.Spec.Provider, observed.Parent.Metadata.Labels["workspace"], observed.Parent.Metadata.Labels["region"])
+		dockerfileAdditions, providerExists, err := c.setupProvider(observed.Parent.Spec.Provider, workspaceOf(observed.Parent), observed.Parent.Metadata.Labels["region"])
 		if err != nil {
 			status := c.errorResponse("setting up backend", err)
 			c.updateStatus(observed, status)
 			return status
 		}
 
-		configMapName, err := container.CreateDockerfileConfigMap(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, dockerfileAdditions, providerExists)
+		configMapName, err := container.CreateDockerfileConfigMap(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, dockerfileAdditions, providerExists, terraformBinary(observed.Parent.Spec), terragruntBinary(observed.Parent.Spec), runnerImage(observed.Parent.Spec))
 		if err != nil {
 			status := c.errorResponse("creating Dockerfile ConfigMap", err)
 			c.updateStatus(observed, status)
@@ -209,29 +417,65 @@ func (c *Controller) handleSyncRequest(observed SyncRequest) map[string]interfac
 			"message": "Running Terraform Destroy",
 		})
 
-		status := c.runDestroy(observed, scriptContent, taggedImageName, secretName, envVars)
-		c.updateStatus(observed, status)
+		status := c.runDestroy(observed, scriptContent, taggedImageName, secretName, envVars, inputs)
+		if status["state"] == "Cancelled" {
+			c.updateStatus(observed, status)
+			return status
+		}
+		if status["state"] == "Failed" {
+			failed := c.destroyFailedStatus(observed, status["message"].(string), now)
+			if hooks, found := status["hooks"]; found {
+				failed["hooks"] = hooks
+			}
+			c.updateStatus(observed, failed)
+			return failed
+		}
 
-		finalStatus := map[string]interface{}{
-			"state":   "Completed",
-			"message": "Destroy process completed successfully",
+		if observed.Parent.Spec.VerifyDestroy {
+			c.updateStatus(observed, map[string]interface{}{
+				"state":   "Progressing",
+				"message": "Verifying Terraform Destroy",
+			})
+			if err := c.verifyDestroy(observed, taggedImageName, secretName, envVars, inputs); err != nil {
+				status := c.destroyFailedStatus(observed, fmt.Sprintf("destroy verification failed: %v", err), now)
+				c.updateStatus(observed, status)
+				return status
+			}
 		}
+
+		hooks, hookErr := c.runPostHooks(observed, hookPostDestroy, observed.Parent.Spec.Hooks.PostDestroy, taggedImageName, secretName, envVars, inputs)
+
+		// The finalizer is only released once the destroy succeeded, failing post hooks do not hold it
+		finalStatus := degradedStatus(destroySucceededStatus(), appendHooks(status["hooks"], hooks), hookErr)
 		c.updateStatus(observed, finalStatus)
-		
+
 		finalStatus["finalized"] = true
 		return finalStatus
 	}
 
+	// The apply is planned first, the plan is summarized in status and checked against the policies.
+	// Pre-apply hooks only run once the plan passed, right before it is applied.
+	podName, plan, blocked := c.planApply(observed, commit, taggedImageName, secretName, envVars, inputs)
+	if blocked != nil {
+		return blocked
+	}
+
 	c.updateStatus(observed, map[string]interface{}{
 		"state":   "Progressing",
 		"message": "Running Terraform Apply",
 	})
 
-	status := c.runApply(observed, scriptContent, taggedImageName, secretName, envVars)
+	status := c.runApply(observed, scriptContent, taggedImageName, secretName, envVars, inputs, podName, plan)
 	c.updateStatus(observed, status)
+	if status["state"] == "Failed" || status["state"] == "Cancelled" {
+		return status
+	}
+
+	hooks, hookErr := c.runPostHooks(observed, hookPostApply, observed.Parent.Spec.Hooks.PostApply, taggedImageName, secretName, envVars, inputs)
+	hooks = appendHooks(status["hooks"], hooks)
 
 	if observed.Parent.Spec.Provider != "" {
-		resources, err := c.executePlugin(observed.Parent.Spec.Provider, observed.Parent.Metadata.Labels["workspace"], observed.Parent.Metadata.Labels["region"])
+		resources, err := c.executePlugin(observed.Parent.Spec.Provider, workspaceOf(observed.Parent), observed.Parent.Metadata.Labels["region"])
 		if err != nil {
 			finalStatus := c.errorResponse("executing plugin", err)
 			c.updateStatus(observed, finalStatus)
@@ -242,19 +486,46 @@ func (c *Controller) handleSyncRequest(observed SyncRequest) map[string]interfac
 			"state":         "Completed",
 			"message":       "Processing completed successfully",
 			"cloudResources": resources,
+			"output":        status["output"],
 		}
+		if commit != "" {
+			pluginStatus["lastAppliedCommit"] = commit
+		}
+		pluginStatus = degradedStatus(clearDrift(observed, pluginStatus), hooks, hookErr)
 		c.updateStatus(observed, pluginStatus)
+		c.enqueueDependents(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name)
 		return pluginStatus
 	}
 
 	finalStatus := map[string]interface{}{
 		"state":   "Completed",
 		"message": "Processing completed successfully",
+		"output":  status["output"],
+	}
+	if commit != "" {
+		finalStatus["lastAppliedCommit"] = commit
 	}
+	finalStatus = degradedStatus(clearDrift(observed, finalStatus), hooks, hookErr)
 	c.updateStatus(observed, finalStatus)
+	c.enqueueDependents(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name)
 	return finalStatus
 }
 
+// suspendedStatus reports a suspended resource. While suspended nothing is applied, and a
+// deletion is held until the resource is resumed so the destroy is not skipped.
+func (c *Controller) suspendedStatus(observed SyncRequest) map[string]interface{} {
+	status := map[string]interface{}{
+		"state":   "Suspended",
+		"message": "Reconciliation is suspended",
+	}
+	if observed.Finalizing {
+		status["message"] = "Reconciliation is suspended, set spec.suspend to false to run the destroy"
+	}
+	log.Printf("Terraform resource %s is suspended", observed.Parent.Metadata.Name)
+	c.updateStatus(observed, status)
+	return status
+}
+
 func (c *Controller) getTaggedImageNameFromConfigMap(namespace, name string) (string, error) {
 	configMapName := fmt.Sprintf("%s-tagged-image", name)
 	configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configMapName, metav1.GetOptions{})
@@ -281,6 +552,35 @@ func (c *Controller) extractEnvVars(variables map[string]string) map[string]stri
 	return util.ExtractEnvVars(variables)
 }
 
+// runEnvVars returns the environment of the run pod: the spec variables plus the Terraform working directory.
+func (c *Controller) runEnvVars(spec TerraformConfigSpec) map[string]string {
+	return mergeEnvVars(c.extractEnvVars(spec.Variables), workingDirEnvVars(spec))
+}
+
+// workspaceOf returns the workspace passed to the provider plugin. The workspace label is still
+// honoured for resources created before spec.workspace, but only spec.workspace selects the
+// Terraform workspace of the run pods.
+func workspaceOf(parent ParentResource) string {
+	if parent.Spec.Workspace != "" {
+		return parent.Spec.Workspace
+	}
+	return parent.Metadata.Labels["workspace"]
+}
+
+// mergeEnvVars returns envVars with the variables of extra added.
+func mergeEnvVars(envVars, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return envVars
+	}
+	if envVars == nil {
+		envVars = make(map[string]string, len(extra))
+	}
+	for key, value := range extra {
+		envVars[key] = value
+	}
+	return envVars
+}
+
 func (c *Controller) setupProvider(providerType, workspace, region string) (string, bool, error) {
 	if providerType == "" {
 		// No provider specified, return without error
@@ -346,19 +646,27 @@ func (c *Controller) updateTaggedImageConfigMap(namespace, name, taggedImageName
 	return nil
 }
 
-func (c *Controller) runDestroy(observed SyncRequest, scriptContent, taggedImageName, secretName string, envVars map[string]string) map[string]interface{} {
+func (c *Controller) runDestroy(observed SyncRequest, scriptContent, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs) map[string]interface{} {
 	// Call to run Terraform destroy
 	var terraformErr error
+	preHooks := observed.Parent.Spec.Hooks.PreDestroy
+	inputs = inputs.With(container.RunPodInputs{Hooks: containerHooks(preHooks)})
 	
 
+	var podName string
+
 	for i := 0; i < maxRetries; i++ {
-		_, terraformErr = container.CreateRunPod(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, scriptContent, envVars, taggedImageName, secretName)
+		podName, terraformErr = container.CreateRunPod(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, scriptContent, envVars, taggedImageName, secretName, runnerOf(observed.Parent.Spec).DestroyCommand(), inputs)
 		
 		if terraformErr == nil {
 			break
 		}
 		log.Printf("Retrying Terraform command due to error: %v", terraformErr)
 		time.Sleep(2 * time.Minute)
+
+		if requestedBy, cancelled := c.takeCancellation(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name); cancelled {
+			return cancelledStatus(requestedBy)
+		}
 	}
 	status := map[string]interface{}{
 		"state":   "Success",
@@ -370,28 +678,83 @@ func (c *Controller) runDestroy(observed SyncRequest, scriptContent, taggedImage
 		return status
 	}
 
+	// The destroy only counts once its pod succeeded
+	_, err := container.WaitForPodSuccess(c.clientset, observed.Parent.Metadata.Namespace, podName)
+	if requestedBy, cancelled := c.takeCancellation(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name); cancelled {
+		return cancelledStatus(requestedBy)
+	}
+	if len(preHooks) > 0 {
+		status["hooks"] = c.preHookStatus(observed.Parent.Metadata.Namespace, podName, hookPreDestroy, preHooks)
+	}
+	if err != nil {
+		status["state"] = "Failed"
+		status["message"] = fmt.Sprintf("Error running Terraform destroy: %v", err)
+		return status
+	}
+
 	return status
 }
 
 
-func (c *Controller) runApply(observed SyncRequest, scriptContent, taggedImageName, secretName string, envVars map[string]string) map[string]interface{} {
+// runApply applies the plan the gated apply pod podName waits with, or runs the deploy script
+// of the script runner in a new pod if podName is empty. plan is the plan evaluated before. The
+// pre-apply hooks run in a pod of their own before the plan is applied, or in the deploy pod.
+func (c *Controller) runApply(observed SyncRequest, scriptContent, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs, podName string, plan *plannedRun) map[string]interface{} {
 	var terraformErr error
-	var podName string
-
-	for i := 0; i < maxRetries; i++ {
-		podName, terraformErr = container.CreateRunPod(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, scriptContent, envVars, taggedImageName, secretName)
+	preHooks := observed.Parent.Spec.Hooks.PreApply
+	gated := podName != ""
+
+	var hooks []interface{}
+	var sensitive map[string]interface{}
+	var sensitiveErr error
+	if gated {
+		if len(preHooks) > 0 {
+			c.updateStatus(observed, map[string]interface{}{
+				"state":   "Progressing",
+				"message": fmt.Sprintf("Running %s hooks", hookPreApply),
+			})
+			hooks, terraformErr = c.runGatedPreHooks(observed, podName, preHooks, taggedImageName, secretName, envVars, inputs)
+			if requestedBy, cancelled := c.takeCancellation(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name); cancelled {
+				c.deletePod(observed.Parent.Metadata.Namespace, podName)
+				return cancelledStatus(requestedBy)
+			}
+		}
+		if terraformErr == nil {
+			if err := container.SendVerdict(c.clientset, c.restConfig, observed.Parent.Metadata.Namespace, podName); err != nil {
+				terraformErr = fmt.Errorf("failed to apply the plan of pod %s: %v", podName, err)
+			}
+		}
+		if terraformErr == nil {
+			// The pod waits for the values of its sensitive outputs to be read before it ends
+			sensitive, sensitiveErr = container.WaitForSensitiveOutputs(c.clientset, c.restConfig, observed.Parent.Metadata.Namespace, podName)
+		}
+		if terraformErr != nil {
+			c.deletePod(observed.Parent.Metadata.Namespace, podName)
+		}
+	} else {
+		inputs = inputs.With(container.RunPodInputs{Hooks: containerHooks(preHooks)})
+	}
+	for i := 0; podName == "" && i < maxRetries; i++ {
+		podName, terraformErr = container.CreateRunPod(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, scriptContent, envVars, taggedImageName, secretName, nil, inputs)
 		
 		if terraformErr == nil {
 			break
 		}
 		log.Printf("Retrying Terraform command due to error: %v", terraformErr)
 		time.Sleep(2 * time.Minute)
+
+		if requestedBy, cancelled := c.takeCancellation(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name); cancelled {
+			return cancelledStatus(requestedBy)
+		}
 	}
 
 	status := map[string]interface{}{
 		"state":   "Success",
 		"message": "Terraform applied successfully",
 	}
+	if hooks != nil {
+		status["hooks"] = hooks
+	}
 	if terraformErr != nil {
 		status["state"] = "Failed"
 		status["message"] = terraformErr.Error()
@@ -400,13 +763,31 @@ func (c *Controller) runApply(observed SyncRequest, scriptContent, taggedImageNa
 
 	// Wait for the pod to complete and retrieve the logs
 	output, err := container.WaitForPodCompletion(c.clientset, observed.Parent.Metadata.Namespace, podName)
+	if requestedBy, cancelled := c.takeCancellation(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name); cancelled {
+		return cancelledStatus(requestedBy)
+	}
+	if len(preHooks) > 0 && !gated {
+		status["hooks"] = c.preHookStatus(observed.Parent.Metadata.Namespace, podName, hookPreApply, preHooks)
+	}
 	if err != nil {
 		status["state"] = "Failed"
 		status["message"] = fmt.Sprintf("Error retrieving Terraform output: %v", err)
 		return status
 	}
 
-	status["output"] = output
+	outputs, resultSensitive := runnerOf(observed.Parent.Spec).Outputs(output)
+	status["output"] = outputs
+	if sensitiveErr != nil {
+		log.Printf("Error reading sensitive outputs of %s, keeping the stored ones: %v", observed.Parent.Metadata.Name, sensitiveErr)
+	} else {
+		for name, value := range sensitive {
+			resultSensitive[name] = value
+		}
+		c.storeSensitiveOutputs(observed, resultSensitive)
+	}
+	if len(observed.Parent.Spec.Imports) > 0 {
+		status["imports"] = c.appliedImportStatus(observed, plan.Summary)
+	}
 
 	// Retrieve ingress URLs and include them in the status
 	ingressURLs, err := kubernetes.GetAllIngressURLs(c.clientset)
@@ -438,20 +819,22 @@ func (c *Controller) errorResponse(action string, err error) map[string]interfac
 	}
 }
 
+// Reconcile starts the queue workers and, once per poll interval, enqueues the Terraform
+// resources whose spec or remote commit changed or whose scheduled apply or drift check is due.
 func (c *Controller) Reconcile() {
+	for i := 0; i < workers; i++ {
+		go c.runWorker()
+	}
+
 	for {
 		c.reconcileLoop()
-		time.Sleep(c.syncInterval)
+		time.Sleep(c.pollInterval)
 	}
 }
 
 func (c *Controller) reconcileLoop() {
 	log.Println("Starting reconciliation loop")
-	resourceList, err := c.dynClient.Resource(schema.GroupVersionResource{
-		Group:    "alustan.io",
-		Version:  "v1alpha1",
-		Resource: "terraforms",
-	}).Namespace("").List(context.Background(), metav1.ListOptions{})
+	resourceList, err := c.dynClient.Resource(terraformGVR).Namespace("").List(context.Background(), metav1.ListOptions{})
 	if err != nil {
 		log.Printf("Error fetching Terraform resources: %v", err)
 		return
@@ -459,26 +842,37 @@ func (c *Controller) reconcileLoop() {
 
 	log.Printf("Fetched %d Terraform resources", len(resourceList.Items))
 
-	var wg sync.WaitGroup
-	for _, item := range resourceList.Items {
-		wg.Add(1)
-		go func(item unstructured.Unstructured) {
-			defer wg.Done()
-			var observed SyncRequest
-			raw, err := item.MarshalJSON()
-			if err != nil {
-				log.Printf("Error marshalling item: %v", err)
-				return
-			}
-			err = json.Unmarshal(raw, &observed)
-			if err != nil {
-				log.Printf("Error unmarshalling item: %v", err)
-				return
-			}
+	now := time.Now()
+
+	for i := range resourceList.Items {
+		item := &resourceList.Items[i]
+		if item.GetDeletionTimestamp() != nil {
+			continue
+		}
 
-			log.Printf("Handling resource: %s", observed.Parent.Metadata.Name)
-			c.handleSyncRequest(observed)
-		}(item)
+		observed, err := syncRequestFromUnstructured(item)
+		if err != nil {
+			log.Printf("Skipping %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+
+		c.handleCancelAnnotation(observed)
+
+		if observed.Parent.Spec.Suspend {
+			log.Printf("Terraform resource %s/%s is suspended, skipping", item.GetNamespace(), item.GetName())
+			continue
+		}
+
+		reason := c.runReason(observed, c.remoteHead(observed), now)
+		if reason != "" {
+			log.Printf("Triggering run for %s/%s: %s", item.GetNamespace(), item.GetName(), reason)
+			c.enqueue(item.GetNamespace(), item.GetName())
+			continue
+		}
+
+		if c.driftCheckDue(observed, now) {
+			log.Printf("Triggering drift check for %s/%s", item.GetNamespace(), item.GetName())
+			c.enqueueDriftCheck(item.GetNamespace(), item.GetName())
+		}
 	}
-	wg.Wait()
 }
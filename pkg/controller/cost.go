@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/cost"
+	"github.com/alustan/terraform-controller/pkg/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// costResourceLimit caps the resources listed in status.cost
+const costResourceLimit = 50
+
+// estimateCost estimates the monthly cost change of a plan with the pricing catalog, and returns
+// it as the status.cost value. Without a catalog nothing is estimated and nil is returned. The
+// catalog is shared by every resource, so a broken one is logged and nothing is estimated
+// rather than failing every plan; policies reading input.cost decide about its absence.
+func (c *Controller) estimateCost(plan map[string]interface{}) map[string]interface{} {
+	estimate, err := c.estimatePlanCost(plan)
+	if err != nil {
+		log.Printf("Error estimating cost: %v", err)
+		return nil
+	}
+	return estimate
+}
+
+// estimatePlanCost estimates the cost of a plan, nil without a catalog.
+func (c *Controller) estimatePlanCost(plan map[string]interface{}) (map[string]interface{}, error) {
+	namespace, name := util.GetPricingConfigMap()
+	configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pricing ConfigMap: %v", err)
+	}
+
+	var catalog cost.Catalog
+	if err := yaml.Unmarshal([]byte(configMap.Data["catalog"]), &catalog); err != nil {
+		return nil, fmt.Errorf("invalid catalog in ConfigMap %s/%s: %v", namespace, name, err)
+	}
+
+	estimate, err := cost.EstimatePlan(catalog, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]interface{}, 0, len(estimate.Resources))
+	for i, resource := range estimate.Resources {
+		if i == costResourceLimit {
+			break
+		}
+		resources = append(resources, map[string]interface{}{
+			"address":       resource.Address,
+			"type":          resource.Type,
+			"monthlyBefore": resource.Before,
+			"monthlyAfter":  resource.After,
+		})
+	}
+	unpriced := make([]interface{}, 0, len(estimate.Unpriced))
+	for i, address := range estimate.Unpriced {
+		if i == costResourceLimit {
+			break
+		}
+		unpriced = append(unpriced, address)
+	}
+
+	return map[string]interface{}{
+		"currency":     estimate.Currency,
+		"monthlyDelta": estimate.MonthlyDelta,
+		"resources":    resources,
+		"unpriced":     unpriced,
+		"estimatedAt":  time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
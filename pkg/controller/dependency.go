@@ -0,0 +1,382 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/kubernetes"
+	"github.com/alustan/terraform-controller/pkg/runner"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// allowDependentsAnnotation lists, comma separated, the namespaces whose resources may depend
+// on a resource of another namespace and read its outputs.
+const allowDependentsAnnotation = "alustan.io/allow-dependents-from"
+
+// Dependency references a Terraform resource that must be applied before, and destroyed after,
+// the resource depending on it. Outputs maps outputs of the dependency to Terraform variables.
+type Dependency struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Outputs   map[string]string `json:"outputs,omitempty"`
+}
+
+// key returns the namespace/name of the dependency, defaulting to the namespace of the dependent.
+func (d Dependency) key(namespace string) string {
+	if d.Namespace != "" {
+		namespace = d.Namespace
+	}
+	return fmt.Sprintf("%s/%s", namespace, d.Name)
+}
+
+// dependencyOutputs are the outputs of the dependencies of a resource as TF_VAR_* environment
+// variables. Sensitive ones are kept apart and passed to run pods through a Secret, so their
+// values appear neither in pod specs nor in status.
+type dependencyOutputs struct {
+	EnvVars   map[string]string
+	Sensitive map[string]string
+}
+
+// dependencyAllowed reports whether resources of a namespace may depend on a resource. Within
+// its namespace anything may depend on it, other namespaces must be listed in its
+// alustan.io/allow-dependents-from annotation, so outputs are never read without its consent.
+func dependencyAllowed(dependency ParentResource, namespace string) bool {
+	if dependency.Metadata.Namespace == namespace {
+		return true
+	}
+	for _, allowed := range strings.Split(dependency.Metadata.Annotations[allowDependentsAnnotation], ",") {
+		if strings.TrimSpace(allowed) == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDependencies returns a Waiting status while a dependency is missing, not ready at its
+// current generation or part of a cycle. Otherwise it returns the outputs exported by the
+// dependencies.
+func (c *Controller) checkDependencies(observed SyncRequest) (dependencyOutputs, map[string]interface{}) {
+	values := dependencyOutputs{EnvVars: map[string]string{}, Sensitive: map[string]string{}}
+	dependencies := observed.Parent.Spec.DependsOn
+	if len(dependencies) == 0 {
+		return values, nil
+	}
+
+	parents, err := c.listParents()
+	if err != nil {
+		return values, c.errorResponse("listing dependencies", err)
+	}
+
+	key := fmt.Sprintf("%s/%s", observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name)
+	if cycle := findDependencyCycle(key, parents); cycle != nil {
+		message := fmt.Sprintf("Dependency cycle: %s", strings.Join(cycle, " -> "))
+		return values, map[string]interface{}{
+			"state":   "Failed",
+			"message": message,
+			"conditions": []interface{}{
+				kubernetes.NewCondition("DependenciesReady", "False", "DependencyCycle", message),
+			},
+		}
+	}
+
+	var waiting, denied []string
+	for _, dependency := range dependencies {
+		dependencyKey := dependency.key(observed.Parent.Metadata.Namespace)
+		parent, found := parents[dependencyKey]
+		if !found {
+			waiting = append(waiting, fmt.Sprintf("%s (not found)", dependencyKey))
+			continue
+		}
+		if !dependencyAllowed(parent, observed.Parent.Metadata.Namespace) {
+			denied = append(denied, dependencyKey)
+			continue
+		}
+		if !dependencyReady(parent) {
+			if statusString(parent.Status, "state") == "Degraded" {
+				waiting = append(waiting, fmt.Sprintf("%s (degraded, its post hooks failed)", dependencyKey))
+			} else {
+				waiting = append(waiting, dependencyKey)
+			}
+			continue
+		}
+
+		missing, err := c.resolveOutputs(parent, dependency, values)
+		if err != nil {
+			return values, c.errorResponse("reading dependency outputs", err)
+		}
+		for _, output := range missing {
+			waiting = append(waiting, fmt.Sprintf("%s (%s)", dependencyKey, output))
+		}
+	}
+
+	if len(denied) > 0 {
+		message := fmt.Sprintf("Dependencies in other namespaces do not allow dependents from %s, list it in their %s annotation: %s",
+			observed.Parent.Metadata.Namespace, allowDependentsAnnotation, strings.Join(denied, ", "))
+		return values, map[string]interface{}{
+			"state":   "Failed",
+			"message": message,
+			"conditions": []interface{}{
+				kubernetes.NewCondition("DependenciesReady", "False", "DependencyNotAllowed", message),
+			},
+		}
+	}
+	if len(waiting) > 0 {
+		message := fmt.Sprintf("Waiting for dependencies: %s", strings.Join(waiting, ", "))
+		log.Printf("%s: %s", key, message)
+		return values, map[string]interface{}{
+			"state":   "Waiting",
+			"message": message,
+			"conditions": []interface{}{
+				kubernetes.NewCondition("DependenciesReady", "False", "DependenciesNotReady", message),
+			},
+		}
+	}
+	return values, nil
+}
+
+// dependencyEnvVars returns the outputs exported by the dependencies that still exist,
+// regardless of their state, for the destroy of a dependent.
+func (c *Controller) dependencyEnvVars(observed SyncRequest) (dependencyOutputs, error) {
+	values := dependencyOutputs{EnvVars: map[string]string{}, Sensitive: map[string]string{}}
+	dependencies := observed.Parent.Spec.DependsOn
+	if len(dependencies) == 0 {
+		return values, nil
+	}
+
+	parents, err := c.listParents()
+	if err != nil {
+		return values, err
+	}
+
+	for _, dependency := range dependencies {
+		parent, found := parents[dependency.key(observed.Parent.Metadata.Namespace)]
+		if !found || !dependencyAllowed(parent, observed.Parent.Metadata.Namespace) {
+			continue
+		}
+		if _, err := c.resolveOutputs(parent, dependency, values); err != nil {
+			return values, err
+		}
+	}
+	return values, nil
+}
+
+// resolveOutputs adds the outputs a dependency maps to variables to values and describes the
+// mapped outputs it cannot provide. Sensitive outputs are redacted in the status of the
+// dependency, their values are read from its outputs Secret.
+func (c *Controller) resolveOutputs(parent ParentResource, dependency Dependency, values dependencyOutputs) ([]string, error) {
+	outputs, _ := parent.Status["output"].(map[string]interface{})
+	var stored map[string]string
+	var missing []string
+	for output, variable := range dependency.Outputs {
+		value, found := outputs[output]
+		if !found {
+			missing = append(missing, fmt.Sprintf("output %s missing", output))
+			continue
+		}
+		if !redactedOutput(value) {
+			values.EnvVars["TF_VAR_"+variable] = outputValue(value)
+			continue
+		}
+
+		if stored == nil {
+			var err error
+			stored, err = container.ReadOutputsSecret(c.clientset, parent.Metadata.Name, parent.Metadata.Namespace)
+			if err != nil {
+				return nil, err
+			}
+		}
+		secret, found := stored[output]
+		if !found {
+			missing = append(missing, fmt.Sprintf("sensitive output %s not stored yet, it is on its next apply", output))
+			continue
+		}
+		values.Sensitive["TF_VAR_"+variable] = secret
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// redactedOutput reports whether the value of a sensitive output was redacted in status. Applies
+// that predate the redaction left the value in status.
+func redactedOutput(value interface{}) bool {
+	wrapped, ok := value.(map[string]interface{})
+	return ok && wrapped["sensitive"] == true && wrapped["value"] == runner.RedactedValue
+}
+
+// dependencyInputs returns the run pod inputs passing the sensitive outputs of the dependencies
+// of a resource through a Secret created for the pod. Callers release them with
+// releaseRunPodInputs once the run pod finished.
+func (c *Controller) dependencyInputs(observed SyncRequest, sensitive map[string]string) (container.RunPodInputs, error) {
+	owner := metav1.OwnerReference{
+		APIVersion: observed.Parent.ApiVersion,
+		Kind:       observed.Parent.Kind,
+		Name:       observed.Parent.Metadata.Name,
+		UID:        observed.Parent.Metadata.UID,
+	}
+	return container.CreateDependencyOutputsSecret(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, owner, sensitive)
+}
+
+// storeSensitiveOutputs stores the values of the sensitive outputs of an apply in the outputs
+// Secret of the resource, which dependents read them from.
+func (c *Controller) storeSensitiveOutputs(observed SyncRequest, sensitive map[string]interface{}) {
+	values := make(map[string]string, len(sensitive))
+	for name, value := range sensitive {
+		values[name] = outputValue(map[string]interface{}{"value": value})
+	}
+	owner := metav1.OwnerReference{
+		APIVersion: observed.Parent.ApiVersion,
+		Kind:       observed.Parent.Kind,
+		Name:       observed.Parent.Metadata.Name,
+		UID:        observed.Parent.Metadata.UID,
+	}
+	if err := container.ApplyOutputsSecret(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, owner, values); err != nil {
+		log.Printf("Error storing sensitive outputs of %s: %v", observed.Parent.Metadata.Name, err)
+	}
+}
+
+// checkDependents returns a Waiting status while other resources still depend on a resource
+// being deleted, so destroys run in the reverse order of applies.
+func (c *Controller) checkDependents(observed SyncRequest) map[string]interface{} {
+	dependents, err := c.dependents(observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name)
+	if err != nil {
+		return c.errorResponse("listing dependents", err)
+	}
+	if len(dependents) == 0 {
+		return nil
+	}
+
+	status := map[string]interface{}{
+		"state":   "Waiting",
+		"message": fmt.Sprintf("Waiting for dependents to be destroyed: %s", strings.Join(dependents, ", ")),
+	}
+	c.updateStatus(observed, status)
+	return status
+}
+
+// enqueueDependents queues an apply of the resources depending on a resource, so they pick up
+// its new outputs.
+func (c *Controller) enqueueDependents(namespace, name string) {
+	dependents, err := c.dependents(namespace, name)
+	if err != nil {
+		log.Printf("Error listing dependents of %s/%s: %v", namespace, name, err)
+		return
+	}
+	for _, dependent := range dependents {
+		parts := strings.SplitN(dependent, "/", 2)
+		c.enqueue(parts[0], parts[1])
+	}
+}
+
+// dependents returns the namespace/name of the resources depending on the given resource.
+// Resources of other namespaces it does not allow as dependents are left out, so they can
+// neither hold its destroy nor be queued by its applies.
+func (c *Controller) dependents(namespace, name string) ([]string, error) {
+	parents, err := c.listParents()
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s", namespace, name)
+	self, found := parents[key]
+	if !found {
+		self = ParentResource{Metadata: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	var dependents []string
+	for dependentKey, parent := range parents {
+		if !dependencyAllowed(self, parent.Metadata.Namespace) {
+			continue
+		}
+		for _, dependency := range parent.Spec.DependsOn {
+			if dependency.key(parent.Metadata.Namespace) == key {
+				dependents = append(dependents, dependentKey)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents, nil
+}
+
+// listParents returns all Terraform resources by namespace/name.
+func (c *Controller) listParents() (map[string]ParentResource, error) {
+	resourceList, err := c.dynClient.Resource(terraformGVR).Namespace("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Terraform resources: %v", err)
+	}
+
+	parents := make(map[string]ParentResource, len(resourceList.Items))
+	for i := range resourceList.Items {
+		observed, err := syncRequestFromUnstructured(&resourceList.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		parents[fmt.Sprintf("%s/%s", observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name)] = observed.Parent
+	}
+	return parents, nil
+}
+
+// findDependencyCycle returns the cycle reachable from start, or nil if there is none.
+func findDependencyCycle(start string, parents map[string]ParentResource) []string {
+	visited := make(map[string]bool)
+	var path []string
+
+	var visit func(key string) []string
+	visit = func(key string) []string {
+		for i, onPath := range path {
+			if onPath == key {
+				return append(append([]string{}, path[i:]...), key)
+			}
+		}
+		if visited[key] {
+			return nil
+		}
+		visited[key] = true
+
+		parent, found := parents[key]
+		if !found {
+			return nil
+		}
+		path = append(path, key)
+		for _, dependency := range parent.Spec.DependsOn {
+			if cycle := visit(dependency.key(parent.Metadata.Namespace)); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		return nil
+	}
+	return visit(start)
+}
+
+// dependencyReady reports whether a dependency was applied successfully at its current generation.
+// A Degraded dependency is not ready: it was applied but its post hooks failed.
+func dependencyReady(parent ParentResource) bool {
+	if statusString(parent.Status, "state") != "Completed" {
+		return false
+	}
+	return statusInt64(parent.Status, "observedGeneration") == parent.Metadata.Generation
+}
+
+// outputValue renders a Terraform output as a TF_VAR_* value. Outputs read from
+// `terraform output -json` carry their value in a "value" field. Strings are passed as is,
+// other values as JSON, which Terraform parses for complex variable types.
+func outputValue(value interface{}) string {
+	if wrapped, ok := value.(map[string]interface{}); ok {
+		if inner, found := wrapped["value"]; found {
+			value = inner
+		}
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/kubernetes"
+)
+
+const (
+	// destroyRetryBaseDelay is the wait after the first failed destroy, doubled on every further failure
+	destroyRetryBaseDelay = time.Minute
+	// destroyRetryMaxDelay caps the wait between destroy attempts
+	destroyRetryMaxDelay = time.Hour
+)
+
+// checkDestroyBackoff returns the current status while a failed destroy waits for its next attempt,
+// or nil if the destroy may run now. The finalizer is kept until the destroy succeeds.
+func (c *Controller) checkDestroyBackoff(observed SyncRequest, now time.Time) map[string]interface{} {
+	retryAt := statusString(observed.Parent.Status, "nextDestroyRetryTime")
+	if retryAt == "" {
+		return nil
+	}
+	next, err := time.Parse(time.RFC3339, retryAt)
+	if err != nil || !now.Before(next) {
+		return nil
+	}
+
+	log.Printf("Destroy of %s failed, retrying at %s", observed.Parent.Metadata.Name, retryAt)
+	return observed.Parent.Status
+}
+
+// destroyFailedStatus records a failed destroy and schedules the next attempt with exponential backoff.
+func (c *Controller) destroyFailedStatus(observed SyncRequest, message string, now time.Time) map[string]interface{} {
+	attempts := statusInt64(observed.Parent.Status, "destroyAttempts") + 1
+	next := now.Add(destroyRetryDelay(attempts))
+
+	log.Printf("Destroy attempt %d of %s failed: %s", attempts, observed.Parent.Metadata.Name, message)
+	return map[string]interface{}{
+		"state":                "Failed",
+		"message":              fmt.Sprintf("Destroy failed, retrying at %s: %s", next.UTC().Format(time.RFC3339), message),
+		"destroyAttempts":      attempts,
+		"nextDestroyRetryTime": next.UTC().Format(time.RFC3339),
+		"conditions": []interface{}{
+			kubernetes.NewCondition("DestroyFailed", "True", "DestroyError", message),
+		},
+	}
+}
+
+// destroySucceededStatus is the finalize response once the destroy is verified. Only this
+// status releases the finalizer.
+func destroySucceededStatus() map[string]interface{} {
+	return map[string]interface{}{
+		"state":                "Completed",
+		"message":              "Destroy process completed successfully",
+		"destroyAttempts":      0,
+		"nextDestroyRetryTime": "",
+		"conditions": []interface{}{
+			kubernetes.NewCondition("DestroyFailed", "False", "DestroySucceeded", "Destroy completed successfully"),
+		},
+	}
+}
+
+func destroyRetryDelay(attempts int64) time.Duration {
+	delay := destroyRetryBaseDelay
+	for i := int64(1); i < attempts && delay < destroyRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > destroyRetryMaxDelay {
+		delay = destroyRetryMaxDelay
+	}
+	return delay
+}
+
+// verifyDestroy confirms that the destroy left nothing behind: the Terraform state must be
+// empty and, when a provider plugin is configured, it must not find any tagged resources. The
+// verification pod is deleted once its result was read, and a result without a resource count
+// fails the verification.
+func (c *Controller) verifyDestroy(observed SyncRequest, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs) error {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+
+	podName, err := container.CreateRunPod(c.clientset, name, namespace, "", envVars, taggedImageName, secretName, runnerOf(observed.Parent.Spec).StateCountCommand(), inputs)
+	if err != nil {
+		return fmt.Errorf("failed to create state verification pod: %v", err)
+	}
+	defer c.deletePod(namespace, podName)
+	output, err := container.WaitForPodCompletion(c.clientset, namespace, podName)
+	if err != nil {
+		return fmt.Errorf("failed to read Terraform state: %v", err)
+	}
+	count, ok := output["stateResources"].(float64)
+	if !ok {
+		return fmt.Errorf("invalid state verification result, expected a stateResources count: %v", output)
+	}
+	if count > 0 {
+		return fmt.Errorf("%d resources remain in the Terraform state", int(count))
+	}
+
+	if observed.Parent.Spec.Provider != "" {
+		resources, err := c.executePlugin(observed.Parent.Spec.Provider, workspaceOf(observed.Parent), observed.Parent.Metadata.Labels["region"])
+		if err != nil {
+			return fmt.Errorf("failed to list cloud resources: %v", err)
+		}
+		if len(resources) > 0 {
+			services := make([]string, 0, len(resources))
+			for service := range resources {
+				services = append(services, service)
+			}
+			sort.Strings(services)
+			return fmt.Errorf("%s resources remain after destroy", strings.Join(services, ", "))
+		}
+	}
+	return nil
+}
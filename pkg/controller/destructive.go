@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/alustan/terraform-controller/pkg/kubernetes"
+	"github.com/alustan/terraform-controller/pkg/terraform"
+)
+
+// Values of spec.destructiveChangePolicy.
+const (
+	DestructiveChangeAllow           = "allow"
+	DestructiveChangeRequireApproval = "requireApproval"
+	DestructiveChangeDeny            = "deny"
+)
+
+// approvePlanAnnotation approves the destructive changes of a plan when set to its plan ID.
+const approvePlanAnnotation = "alustan.io/approve-plan"
+
+// checkDestructiveChanges applies spec.destructiveChangePolicy to a plan of the given commit. It
+// returns the DestructiveChangesAllowed condition when the apply may run, or the status of the
+// run when it may not.
+func checkDestructiveChanges(observed SyncRequest, commit string, summary terraform.PlanSummary) (condition, blocked map[string]interface{}) {
+	spec := observed.Parent.Spec
+	if spec.DestructiveChangePolicy == "" || spec.DestructiveChangePolicy == DestructiveChangeAllow {
+		return nil, nil
+	}
+
+	resources := destructiveResources(summary, spec.DestructiveResourceTypes)
+	if len(resources) == 0 {
+		return kubernetes.NewCondition("DestructiveChangesAllowed", "True", "NoDestructiveChanges", "The plan deletes or replaces no guarded resource"), nil
+	}
+
+	addresses := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		addresses = append(addresses, resource.Address)
+	}
+	changes := fmt.Sprintf("The plan deletes or replaces %d resources (%s)", len(resources), strings.Join(addresses, ", "))
+
+	if spec.DestructiveChangePolicy == DestructiveChangeDeny {
+		message := changes + " and spec.destructiveChangePolicy is deny"
+		return nil, map[string]interface{}{
+			"state":      "Failed",
+			"message":    message,
+			"conditions": []interface{}{kubernetes.NewCondition("DestructiveChangesAllowed", "False", "Denied", message)},
+		}
+	}
+
+	planID := destructivePlanID(commit, observed.Parent.Metadata.Generation, resources)
+	if observed.Parent.Metadata.Annotations[approvePlanAnnotation] == planID {
+		return kubernetes.NewCondition("DestructiveChangesAllowed", "True", "Approved", fmt.Sprintf("Plan %s approved", planID)), nil
+	}
+
+	message := fmt.Sprintf("%s, approve them with: kubectl annotate tf %s %s=%s --overwrite", changes, observed.Parent.Metadata.Name, approvePlanAnnotation, planID)
+	pending := make([]interface{}, 0, len(resources))
+	for _, resource := range resources {
+		pending = append(pending, map[string]interface{}{
+			"address": resource.Address,
+			"type":    resource.Type,
+			"action":  resource.Action,
+		})
+	}
+	return nil, map[string]interface{}{
+		"state":   "AwaitingApproval",
+		"message": message,
+		"pendingApproval": map[string]interface{}{
+			"planId":    planID,
+			"commit":    commit,
+			"resources": pending,
+		},
+		"conditions": []interface{}{kubernetes.NewCondition("DestructiveChangesAllowed", "False", "AwaitingApproval", message)},
+	}
+}
+
+// destructiveResources returns the deleted and replaced resources whose type matches one of the
+// patterns, e.g. aws_db_instance or aws_eks_*, or all of them without patterns.
+func destructiveResources(summary terraform.PlanSummary, typePatterns []string) []terraform.PlannedResource {
+	var resources []terraform.PlannedResource
+	for _, resource := range summary.Resources {
+		if resource.Action != terraform.ActionDelete && resource.Action != terraform.ActionReplace {
+			continue
+		}
+		if len(typePatterns) == 0 || matchesAny(typePatterns, resource.Type) {
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// destructivePlanID identifies a set of destructive changes of a commit and generation of the
+// spec. It does not depend on anything else in the plan, so an approval holds across plans of
+// the same code making the same destructive changes, but not once the code or spec changed.
+func destructivePlanID(commit string, generation int64, resources []terraform.PlannedResource) string {
+	changes := []string{"commit " + commit, fmt.Sprintf("generation %d", generation)}
+	for _, resource := range resources {
+		changes = append(changes, resource.Action+" "+resource.Address)
+	}
+	sort.Strings(changes[2:])
+	sum := sha256.Sum256([]byte(strings.Join(changes, "\n")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// approvalGranted reports whether a run awaiting approval was approved since.
+func approvalGranted(observed SyncRequest) bool {
+	status := observed.Parent.Status
+	if statusString(status, "state") != "AwaitingApproval" {
+		return false
+	}
+	pending, _ := status["pendingApproval"].(map[string]interface{})
+	planID := statusString(pending, "planId")
+	return planID != "" && observed.Parent.Metadata.Annotations[approvePlanAnnotation] == planID
+}
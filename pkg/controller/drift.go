@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/kubernetes"
+)
+
+// handleDriftCheck runs a plan-only Terraform run against the last applied image and reports
+// whether the infrastructure drifted from the code. Nothing is built or applied. The result is
+// recorded in driftDetected, driftPlan and the Drifted condition only, so the state of the last
+// run, e.g. Failed or AwaitingApproval, is never overwritten by a drift check.
+func (c *Controller) handleDriftCheck(observed SyncRequest) map[string]interface{} {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+	secretName := fmt.Sprintf("%s-container-secret", name)
+	now := time.Now()
+
+	if observed.Parent.Spec.Suspend {
+		return c.suspendedStatus(observed)
+	}
+
+	dependencies, waiting := c.checkDependencies(observed)
+	if waiting != nil {
+		log.Printf("Skipping drift check of %s: %v", name, waiting["message"])
+		return nil
+	}
+
+	taggedImageName, err := c.getTaggedImageNameFromConfigMap(namespace, name)
+	if err != nil {
+		log.Printf("Skipping drift check of %s, no applied image: %v", name, err)
+		return nil
+	}
+
+	fields := map[string]interface{}{
+		"lastDriftCheckTime": now.UTC().Format(time.RFC3339),
+	}
+	nextCheck, _, err := nextDriftCheckTime(observed.Parent.Spec, now)
+	if err != nil {
+		return c.driftCheckFailed(observed, fields, "parsing schedule", err)
+	}
+	fields["nextDriftCheckTime"] = nextCheck.UTC().Format(time.RFC3339)
+
+	if err := c.checkVariableSources(namespace, observed.Parent.Spec); err != nil {
+		return c.driftCheckFailed(observed, fields, "reading variables", err)
+	}
+	inputs, err := c.runPodInputs(observed, builtCommit(observed), dependencies.Sensitive)
+	if err != nil {
+		return c.driftCheckFailed(observed, fields, "rendering variables", err)
+	}
+	defer c.releaseRunPodInputs(observed.Parent.Metadata.Namespace, inputs)
+
+	plan, err := c.runPlan(observed, taggedImageName, secretName, mergeEnvVars(c.runEnvVars(observed.Parent.Spec), dependencies.EnvVars), inputs)
+	if requestedBy, cancelled := c.takeCancellation(namespace, name); cancelled {
+		return c.driftCheckFailed(observed, fields, "running drift check", fmt.Errorf("cancelled by %s", requestedBy))
+	}
+	if err != nil {
+		return c.driftCheckFailed(observed, fields, "running drift check", err)
+	}
+
+	driftDetected := plan.Summary.HasChanges()
+	fields["driftDetected"] = driftDetected
+	fields["driftPlan"] = plan.Status
+	condition := kubernetes.NewCondition("Drifted", "False", "NoDrift", "No drift detected")
+	if driftDetected {
+		condition = kubernetes.NewCondition("Drifted", "True", "DriftDetected", fmt.Sprintf("Drift detected between the infrastructure and the applied code (%s)", planMessage(plan.Summary)))
+	}
+	fields["conditions"] = []interface{}{condition}
+	c.mergeDriftStatus(observed, fields)
+	return fields
+}
+
+// driftCheckFailed records a drift check that could not complete in the Drifted condition.
+func (c *Controller) driftCheckFailed(observed SyncRequest, fields map[string]interface{}, action string, err error) map[string]interface{} {
+	log.Printf("Error %s for drift check of %s: %v", action, observed.Parent.Metadata.Name, err)
+	fields["conditions"] = []interface{}{kubernetes.NewCondition("Drifted", "Unknown", "DriftCheckFailed", fmt.Sprintf("Error %s: %v", action, err))}
+	c.mergeDriftStatus(observed, fields)
+	return fields
+}
+
+func (c *Controller) mergeDriftStatus(observed SyncRequest, fields map[string]interface{}) {
+	if err := kubernetes.MergeStatus(c.dynClient, observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name, fields); err != nil {
+		log.Printf("Error recording drift check of %s: %v", observed.Parent.Metadata.Name, err)
+	}
+}
+
+// clearDrift resets the drift check result once an apply brought the infrastructure back in
+// line with the code.
+func clearDrift(observed SyncRequest, status map[string]interface{}) map[string]interface{} {
+	if _, found := observed.Parent.Status["driftDetected"]; !found {
+		return status
+	}
+	status["driftDetected"] = false
+	conditions, _ := status["conditions"].([]interface{})
+	status["conditions"] = append(conditions, kubernetes.NewCondition("Drifted", "False", "Applied", "The infrastructure matches the applied code"))
+	return status
+}
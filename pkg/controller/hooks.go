@@ -0,0 +1,205 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+)
+
+// gateExtendInterval is how often the wait of a gated apply pod is restarted while its pre hooks run
+const gateExtendInterval = time.Minute
+
+// Hook phases, as shown in status.hooks.
+const (
+	hookPreApply    = "preApply"
+	hookPostApply   = "postApply"
+	hookPreDestroy  = "preDestroy"
+	hookPostDestroy = "postDestroy"
+)
+
+// Hook is a command or container image run before or after Terraform. Without an image the
+// command runs in the image built for the resource, from the repository root.
+type Hook struct {
+	Name    string   `json:"name"`
+	Image   string   `json:"image,omitempty"`
+	Command []string `json:"command,omitempty"`
+}
+
+// Hooks are run in order before and after applies and destroys. Pre hooks run in the pod of
+// the run and a failing one aborts it; the pre-apply hooks of runners applying saved plans run
+// in a pod of their own once the plan passed its checks. Post hooks run in a follow-up pod
+// once the run succeeded and a failing one marks it Degraded.
+type Hooks struct {
+	PreApply    []Hook `json:"preApply,omitempty"`
+	PostApply   []Hook `json:"postApply,omitempty"`
+	PreDestroy  []Hook `json:"preDestroy,omitempty"`
+	PostDestroy []Hook `json:"postDestroy,omitempty"`
+}
+
+// validateHooks rejects hooks that have nothing to run.
+func validateHooks(hooks Hooks) error {
+	phases := []struct {
+		name  string
+		hooks []Hook
+	}{
+		{hookPreApply, hooks.PreApply},
+		{hookPostApply, hooks.PostApply},
+		{hookPreDestroy, hooks.PreDestroy},
+		{hookPostDestroy, hooks.PostDestroy},
+	}
+	for _, p := range phases {
+		phase, phaseHooks := p.name, p.hooks
+		names := map[string]bool{}
+		for _, hook := range phaseHooks {
+			if hook.Name == "" {
+				return fmt.Errorf("%s hooks require a name", phase)
+			}
+			if names[hook.Name] {
+				return fmt.Errorf("duplicate %s hook %q", phase, hook.Name)
+			}
+			names[hook.Name] = true
+			if hook.Image == "" && len(hook.Command) == 0 {
+				return fmt.Errorf("%s hook %q requires a command or an image", phase, hook.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func containerHooks(hooks []Hook) []container.Hook {
+	converted := make([]container.Hook, 0, len(hooks))
+	for _, hook := range hooks {
+		converted = append(converted, container.Hook{Name: hook.Name, Image: hook.Image, Command: hook.Command})
+	}
+	return converted
+}
+
+// hookStatus returns the status.hooks entries of hook results.
+func hookStatus(phase string, results []container.HookResult) []interface{} {
+	entries := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		entries = append(entries, map[string]interface{}{
+			"name":    result.Name,
+			"phase":   phase,
+			"state":   result.State,
+			"message": result.Message,
+		})
+	}
+	return entries
+}
+
+// preHookStatus returns the status.hooks entries of the pre hooks run by a completed run pod.
+func (c *Controller) preHookStatus(namespace, podName, phase string, hooks []Hook) []interface{} {
+	results, err := container.HookResults(c.clientset, namespace, podName, containerHooks(hooks))
+	if err != nil {
+		log.Printf("Error reading %s hook results of pod %s: %v", phase, podName, err)
+		return nil
+	}
+	return hookStatus(phase, results)
+}
+
+// runPostHooks runs the post hooks of a run of a resource, reporting them in its status.
+func (c *Controller) runPostHooks(observed SyncRequest, phase string, hooks []Hook, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs) ([]interface{}, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	c.updateStatus(observed, map[string]interface{}{
+		"state":   "Progressing",
+		"message": fmt.Sprintf("Running %s hooks", phase),
+	})
+	return c.runHookPod(observed, phase, hooks, taggedImageName, secretName, envVars, inputs)
+}
+
+// runHookPod runs post hooks in a follow-up pod with the environment and volumes of the run.
+// It returns their status.hooks entries and an error naming the first hook that failed.
+func (c *Controller) runHookPod(observed SyncRequest, phase string, hooks []Hook, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs) ([]interface{}, error) {
+	hookInputs := hookPodInputs(hooks, inputs)
+	podName, err := container.CreateRunPod(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, "", envVars, taggedImageName, secretName, []string{"true"}, hookInputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s hook pod: %v", phase, err)
+	}
+	return c.waitForHookPod(observed.Parent.Metadata.Namespace, podName, phase, hookInputs.Hooks)
+}
+
+// runGatedPreHooks runs the pre-apply hooks of an apply whose plan passed its checks in a pod of
+// their own, while the gated apply pod gatePodName waits for its verdict. Its wait is restarted
+// until the hooks finished, so it outlasts them. It returns their status.hooks entries and an
+// error naming the first hook that failed.
+func (c *Controller) runGatedPreHooks(observed SyncRequest, gatePodName string, hooks []Hook, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs) ([]interface{}, error) {
+	namespace := observed.Parent.Metadata.Namespace
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(gateExtendInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := container.ExtendGate(c.clientset, c.restConfig, namespace, gatePodName); err != nil {
+					log.Printf("Error extending the wait of pod %s: %v", gatePodName, err)
+				}
+			}
+		}
+	}()
+
+	hookInputs := hookPodInputs(hooks, inputs)
+	podName, err := container.CreateHookPod(c.clientset, observed.Parent.Metadata.Name, namespace, envVars, taggedImageName, secretName, []string{"true"}, hookInputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s hook pod: %v", hookPreApply, err)
+	}
+	return c.waitForHookPod(namespace, podName, hookPreApply, hookInputs.Hooks)
+}
+
+// hookPodInputs returns the inputs of a pod running hooks with the environment and volumes of the
+// run. The setup only prepares Terraform, the hooks run without it.
+func hookPodInputs(hooks []Hook, inputs container.RunPodInputs) container.RunPodInputs {
+	return container.RunPodInputs{
+		EnvFrom:      inputs.EnvFrom,
+		Env:          inputs.Env,
+		Volumes:      inputs.Volumes,
+		VolumeMounts: inputs.VolumeMounts,
+		Hooks:        containerHooks(hooks),
+	}
+}
+
+// waitForHookPod waits for the hooks of a hook pod and returns their status.hooks entries and an
+// error naming the first hook that failed.
+func (c *Controller) waitForHookPod(namespace, podName, phase string, hooks []container.Hook) ([]interface{}, error) {
+	results, err := container.WaitForHooks(c.clientset, namespace, podName, hooks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s hooks: %v", phase, err)
+	}
+
+	for _, result := range results {
+		if result.State == container.HookFailed {
+			return hookStatus(phase, results), fmt.Errorf("%s hook %s failed: %s", phase, result.Name, result.Message)
+		}
+	}
+	return hookStatus(phase, results), nil
+}
+
+// appendHooks appends status.hooks entries to the entries of an earlier status.
+func appendHooks(earlier interface{}, hooks []interface{}) []interface{} {
+	entries, _ := earlier.([]interface{})
+	if len(entries) == 0 {
+		return hooks
+	}
+	return append(append([]interface{}{}, entries...), hooks...)
+}
+
+// degradedStatus marks the final status of a run whose post hooks failed.
+func degradedStatus(status map[string]interface{}, hooks []interface{}, hookErr error) map[string]interface{} {
+	if hooks != nil {
+		status["hooks"] = hooks
+	}
+	if hookErr != nil {
+		status["state"] = "Degraded"
+		status["message"] = fmt.Sprintf("%s, but %v", status["message"], hookErr)
+	}
+	return status
+}
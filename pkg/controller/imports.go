@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/terraform"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// States of the imports in status.imports.
+const (
+	importPlanned     = "Planned"
+	importImported    = "Imported"
+	importNotPlanned  = "NotPlanned"
+	importNotImported = "NotImported"
+)
+
+// Import imports the existing object with the given ID into the state at a resource address.
+// The controller renders the imports as import blocks in the working directory, so they are
+// planned and applied with the rest of the configuration.
+type Import struct {
+	To string `json:"to"`
+	ID string `json:"id"`
+}
+
+// validateImports rejects imports that cannot be rendered or that the runner cannot apply.
+func validateImports(spec TerraformConfigSpec) error {
+	if spec.GenerateImportConfig && len(spec.Imports) == 0 {
+		return fmt.Errorf("generateImportConfig requires imports")
+	}
+	if len(spec.Imports) > 0 && runnerName(spec) == RunnerTerragrunt {
+		return fmt.Errorf("imports are not supported with the terragrunt runner, declare import blocks in the units")
+	}
+	addresses := map[string]bool{}
+	for _, imp := range spec.Imports {
+		if err := terraform.ValidateImport(terraform.Import{To: imp.To, ID: imp.ID}); err != nil {
+			return err
+		}
+		if addresses[imp.To] {
+			return fmt.Errorf("duplicate import to %s", imp.To)
+		}
+		addresses[imp.To] = true
+	}
+	return nil
+}
+
+// importInputs returns the run pod inputs writing the import blocks of a resource, if any.
+func importInputs(spec TerraformConfigSpec) (container.RunPodInputs, error) {
+	if len(spec.Imports) == 0 {
+		return container.RunPodInputs{}, nil
+	}
+	if err := validateImports(spec); err != nil {
+		return container.RunPodInputs{}, err
+	}
+	imports := make([]terraform.Import, 0, len(spec.Imports))
+	for _, imp := range spec.Imports {
+		imports = append(imports, terraform.Import{To: imp.To, ID: imp.ID})
+	}
+	return container.ImportInputs(terraform.RenderImports(imports)), nil
+}
+
+// importStatus returns the status.imports entries of a plan: an import is Planned when the plan
+// imports it, Imported when its address is already in the state and NotPlanned otherwise.
+func importStatus(imports []Import, summary terraform.PlanSummary) []interface{} {
+	importing := map[string]bool{}
+	for _, imp := range summary.Imports {
+		importing[imp.Address] = true
+	}
+	planned := map[string]bool{}
+	for _, address := range summary.Addresses {
+		planned[address] = true
+	}
+	entries := make([]interface{}, 0, len(imports))
+	for _, imp := range imports {
+		state := importNotPlanned
+		switch {
+		case importing[imp.To]:
+			state = importPlanned
+		case planned[imp.To]:
+			state = importImported
+		}
+		entries = append(entries, map[string]interface{}{"to": imp.To, "id": imp.ID, "state": state})
+	}
+	return entries
+}
+
+// appliedImportStatus returns the status.imports entries once an apply of a plan succeeded.
+// Runners applying saved plans applied that plan, so the imports it planned are Imported. The
+// deploy script of the script runner plans again, so the imports are looked up in the state:
+// Imported when their address is in it, NotImported otherwise. The plan entries are kept if
+// the state cannot be read.
+func (c *Controller) appliedImportStatus(observed SyncRequest, summary terraform.PlanSummary) []interface{} {
+	entries := importStatus(observed.Parent.Spec.Imports, summary)
+	if runnerOf(observed.Parent.Spec).SavedPlan() {
+		for _, entry := range entries {
+			if fields := entry.(map[string]interface{}); fields["state"] == importPlanned {
+				fields["state"] = importImported
+			}
+		}
+		return entries
+	}
+
+	state, err := c.showState(observed)
+	if err != nil {
+		log.Printf("Error reading the imports of %s from its state: %v", observed.Parent.Metadata.Name, err)
+		return entries
+	}
+	inState := map[string]bool{}
+	for _, resource := range state.Resources {
+		inState[resource.Address] = true
+	}
+	for _, entry := range entries {
+		fields := entry.(map[string]interface{})
+		fields["state"] = importNotImported
+		if inState[fields["to"].(string)] {
+			fields["state"] = importImported
+		}
+	}
+	return entries
+}
+
+// generatedConfigStatus blocks the apply of a plan that generated configuration for imports.
+// The generated resources only exist in the plan, so the configuration must be added to the
+// repository before they can be applied.
+func generatedConfigStatus(observed SyncRequest, configMapName string) map[string]interface{} {
+	return map[string]interface{}{
+		"state": "ImportConfigGenerated",
+		"message": fmt.Sprintf("Configuration was generated for imports without one, add it to the repository to apply them. Download it from /api/v1/namespaces/%s/terraforms/%s/generated-config or ConfigMap %s",
+			observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name, configMapName),
+	}
+}
+
+// HandleGeneratedConfig returns the configuration last generated for the imports of a Terraform
+// resource to callers allowed to get it.
+func (c *Controller) HandleGeneratedConfig(r *gin.Context) {
+	namespace := r.Param("namespace")
+	name := r.Param("name")
+
+	if _, ok := c.authorize(r, "get", namespace, name); !ok {
+		return
+	}
+
+	configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), container.GeneratedConfigMapName(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		r.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no configuration was generated for terraform %s/%s", namespace, name)})
+		return
+	}
+	if err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	r.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"-generated.tf"))
+	r.String(http.StatusOK, configMap.Data[container.GeneratedConfigKey])
+}
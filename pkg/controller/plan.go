@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/terraform"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// planResourceLimit caps the resource changes listed in status.plan
+const planResourceLimit = 50
+
+// plannedRun is a saved plan of a resource.
+type plannedRun struct {
+	JSON    map[string]interface{}
+	Summary terraform.PlanSummary
+	// Status is the status.plan value summarizing the plan
+	Status map[string]interface{}
+	// Cost is the status.cost value estimating its monthly cost change, nil without a pricing catalog
+	Cost map[string]interface{}
+	// GeneratedConfigMap holds the configuration the plan generated for imports, empty if none was
+	GeneratedConfigMap string
+}
+
+// runPlan plans the resource with the given image and stores the plan text in ConfigMaps
+// referenced from the returned summary. The state is not locked and the plan is not applied.
+func (c *Controller) runPlan(observed SyncRequest, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs) (*plannedRun, error) {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+
+	podName, err := container.CreateRunPod(c.clientset, name, namespace, "", envVars, taggedImageName, secretName, runnerOf(observed.Parent.Spec).PlanCommand(), inputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plan pod: %v", err)
+	}
+	output, err := container.WaitForPlan(c.clientset, namespace, podName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan: %v", err)
+	}
+	// The plan is kept in the ConfigMaps and the status, the pod logs are not needed anymore
+	c.deletePod(namespace, podName)
+	return c.recordPlan(observed, output)
+}
+
+// gatePlan creates the apply pod of a runner applying saved plans and waits for the plan it
+// made with the given extra flags. The pod waits for the verdict of the controller: it applies
+// exactly that plan once sent one with container.SendVerdict, and is deleted otherwise.
+func (c *Controller) gatePlan(observed SyncRequest, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs, flags string) (string, *plannedRun, error) {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+
+	podName, err := container.CreateRunPod(c.clientset, name, namespace, "", envVars, taggedImageName, secretName, runnerOf(observed.Parent.Spec).GateCommand(flags), inputs)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create apply pod: %v", err)
+	}
+	output, err := container.WaitForGate(c.clientset, namespace, podName)
+	if err != nil {
+		return podName, nil, fmt.Errorf("failed to plan: %v", err)
+	}
+	plan, err := c.recordPlan(observed, output)
+	return podName, plan, err
+}
+
+// deletePod deletes a pod whose logs are not needed anymore.
+func (c *Controller) deletePod(namespace, podName string) {
+	if err := container.DeletePod(c.clientset, namespace, podName); err != nil {
+		log.Printf("Error deleting pod: %v", err)
+	}
+}
+
+// recordPlan summarizes a plan, stores its text and the configuration it generated in
+// ConfigMaps and estimates its cost.
+func (c *Controller) recordPlan(observed SyncRequest, output container.PlanOutput) (*plannedRun, error) {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+	plan := output.JSON
+
+	summary, err := terraform.SummarizePlan(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := metav1.OwnerReference{
+		APIVersion: observed.Parent.ApiVersion,
+		Kind:       observed.Parent.Kind,
+		Name:       name,
+		UID:        observed.Parent.Metadata.UID,
+	}
+	configMaps, err := container.ApplyPlanConfigMaps(c.clientset, name, namespace, owner, output.Text)
+	if err != nil {
+		// The summary is still useful without the plan text
+		log.Printf("Error storing plan text of %s: %v", name, err)
+	}
+
+	var generatedConfigMap string
+	if strings.TrimSpace(output.GeneratedConfig) != "" {
+		generatedConfigMap, err = container.ApplyGeneratedConfigMap(c.clientset, name, namespace, owner, output.GeneratedConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	estimate := c.estimateCost(plan)
+	if estimate != nil {
+		// Policies read the estimate as input.cost
+		plan["cost"] = estimate
+	}
+
+	return &plannedRun{JSON: plan, Summary: summary, Status: planStatus(summary, configMaps), Cost: estimate, GeneratedConfigMap: generatedConfigMap}, nil
+}
+
+// planStatus returns the status.plan value of a plan summary.
+func planStatus(summary terraform.PlanSummary, configMaps []string) map[string]interface{} {
+	resources := make([]interface{}, 0, len(summary.Resources))
+	for i, resource := range summary.Resources {
+		if i == planResourceLimit {
+			break
+		}
+		resources = append(resources, map[string]interface{}{
+			"address": resource.Address,
+			"type":    resource.Type,
+			"action":  resource.Action,
+		})
+	}
+
+	textConfigMaps := make([]interface{}, 0, len(configMaps))
+	for _, configMap := range configMaps {
+		textConfigMaps = append(textConfigMaps, configMap)
+	}
+
+	return map[string]interface{}{
+		"create":             int64(summary.Create),
+		"update":             int64(summary.Update),
+		"replace":            int64(summary.Replace),
+		"delete":             int64(summary.Delete),
+		"import":             int64(len(summary.Imports)),
+		"destructive":        summary.Destructive(),
+		"resources":          resources,
+		"resourcesTruncated": len(summary.Resources) > planResourceLimit,
+		"textConfigMaps":     textConfigMaps,
+		"plannedAt":          time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// planMessage describes a plan summary the way Terraform does.
+func planMessage(summary terraform.PlanSummary) string {
+	if !summary.HasChanges() {
+		return "Plan: no changes"
+	}
+	message := fmt.Sprintf("%d to add, %d to change, %d to replace, %d to destroy", summary.Create, summary.Update, summary.Replace, summary.Delete)
+	if len(summary.Imports) > 0 {
+		message = fmt.Sprintf("%d to import, %s", len(summary.Imports), message)
+	}
+	return "Plan: " + message
+}
+
+// costMessage describes the monthly cost change of a status.cost value.
+func costMessage(estimate map[string]interface{}) string {
+	return fmt.Sprintf("estimated monthly cost change %+.2f %s", estimate["monthlyDelta"], estimate["currency"])
+}
+
+// planApply plans the apply of a resource, records the plan summary in status, evaluates the
+// policies against the plan and applies the destructive change policy. Runners applying saved
+// plans plan in their apply pod, whose name is returned: it applies the evaluated plan once
+// runApply sends the verdict. The script runner plans in a pod of its own, and its deploy
+// script plans again. It returns the plan, or the final status of the run if the apply must
+// not run.
+func (c *Controller) planApply(observed SyncRequest, commit, taggedImageName, secretName string, envVars map[string]string, inputs container.RunPodInputs) (string, *plannedRun, map[string]interface{}) {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+	gated := runnerOf(observed.Parent.Spec).SavedPlan()
+
+	c.updateStatus(observed, map[string]interface{}{
+		"state":   "Progressing",
+		"message": "Running Terraform Plan",
+	})
+
+	var podName string
+	var plan *plannedRun
+	var err error
+	if gated {
+		podName, plan, err = c.gatePlan(observed, taggedImageName, secretName, envVars, inputs, "")
+	} else {
+		plan, err = c.runPlan(observed, taggedImageName, secretName, envVars, inputs)
+	}
+	if requestedBy, cancelled := c.takeCancellation(namespace, name); cancelled {
+		status := cancelledStatus(requestedBy)
+		c.updateStatus(observed, status)
+		return "", nil, status
+	}
+	if blocked := c.evaluatePlan(observed, commit, plan, err, gated); blocked != nil {
+		if podName != "" {
+			c.deletePod(namespace, podName)
+		}
+		return "", nil, blocked
+	}
+	return podName, plan, nil
+}
+
+// evaluatePlan records the plan of an apply in status and returns the final status of the run
+// if the apply must not run: planning failed, the plan generated configuration, a policy denied
+// it or the destructive change policy blocks it.
+func (c *Controller) evaluatePlan(observed SyncRequest, commit string, plan *plannedRun, err error, gated bool) map[string]interface{} {
+	if err != nil {
+		status := c.errorResponse("planning", err)
+		c.updateStatus(observed, status)
+		return status
+	}
+
+	condition, blocked := checkDestructiveChanges(observed, commit, plan.Summary)
+	status := map[string]interface{}{
+		"state":   "Progressing",
+		"message": planMessage(plan.Summary),
+		"plan":    plan.Status,
+	}
+	if plan.Cost != nil {
+		status["cost"] = plan.Cost
+		status["message"] = fmt.Sprintf("%s, %s", planMessage(plan.Summary), costMessage(plan.Cost))
+	}
+	if condition != nil {
+		status["conditions"] = []interface{}{condition}
+	}
+	if imports := observed.Parent.Spec.Imports; len(imports) > 0 {
+		status["imports"] = importStatus(imports, plan.Summary)
+	}
+	c.updateStatus(observed, status)
+
+	// Generated resources only exist in the plan, so they are never applied
+	if plan.GeneratedConfigMap != "" {
+		generated := generatedConfigStatus(observed, plan.GeneratedConfigMap)
+		c.updateStatus(observed, generated)
+		return generated
+	}
+
+	// Policies are evaluated first so a plan they deny is never left awaiting approval
+	if denied := c.checkPolicies(observed, plan.JSON, gated); denied != nil {
+		return denied
+	}
+	if blocked != nil {
+		c.updateStatus(observed, blocked)
+		return blocked
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/kubernetes"
+	"github.com/alustan/terraform-controller/pkg/policy"
+	"github.com/alustan/terraform-controller/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// policyLabel marks the ConfigMaps holding Rego policies, in entries ending in .rego.
+const policyLabel = "alustan.io/policy"
+
+// Policy selects the policy ConfigMaps of the namespace of the resource evaluated before each apply.
+// Policies of the controller namespace labelled alustan.io/policy=true apply to every resource.
+type Policy struct {
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+// checkPolicies evaluates the policies against the plan of an apply. Warnings are recorded
+// in status, deny violations block the apply and are returned as its final status.
+func (c *Controller) checkPolicies(observed SyncRequest, plan map[string]interface{}, gated bool) map[string]interface{} {
+	status, blocked := c.evaluatePolicies(observed, plan, gated)
+	if status != nil {
+		c.updateStatus(observed, status)
+	}
+	if blocked {
+		return status
+	}
+	return nil
+}
+
+// evaluatePolicies evaluates the policies against a plan and returns the status reporting the
+// result, nil when no policies apply and none were reported before, and whether they block the
+// apply. Unless gated, the apply does not apply the evaluated plan, so any policy blocks it.
+// Broken policies are listed in status: those of the namespace of the resource block the apply,
+// while those of the controller namespace are skipped so they do not block the applies of
+// every resource.
+func (c *Controller) evaluatePolicies(observed SyncRequest, plan map[string]interface{}, gated bool) (map[string]interface{}, bool) {
+	name := observed.Parent.Metadata.Name
+
+	modules, err := c.loadPolicies(observed)
+	if err != nil {
+		return c.errorResponse("loading policies", err), true
+	}
+	if len(modules) == 0 {
+		if _, found := observed.Parent.Status["policy"]; found {
+			return map[string]interface{}{
+				"state":      "Progressing",
+				"message":    "No policies apply",
+				"policy":     map[string]interface{}{"policies": []interface{}{}},
+				"conditions": []interface{}{kubernetes.NewCondition("PolicyCompliant", "True", "NoPolicies", "No policies apply")},
+			}, false
+		}
+		return nil, false
+	}
+	if !gated {
+		message := fmt.Sprintf("%d policies apply but the %s runner does not apply the plan they evaluate, use the builtin or terragrunt runner", len(modules), runnerName(observed.Parent.Spec))
+		return map[string]interface{}{
+			"state":      "Failed",
+			"message":    message,
+			"conditions": []interface{}{kubernetes.NewCondition("PolicyCompliant", "False", "NotEnforceable", message)},
+		}, true
+	}
+
+	violations, warnings, errs := policy.Evaluate(context.Background(), modules, plan)
+	log.Printf("Policies of %s: %d violations, %d warnings, %d errors", name, len(violations), len(warnings), len(errs))
+	var blocking []string
+	for _, result := range errs {
+		log.Printf("Broken policy %s: %s", result.Policy, result.Message)
+		if !sharedPolicy(observed, result.Policy) {
+			blocking = append(blocking, result.Policy)
+		}
+	}
+
+	policyNames := make([]interface{}, 0, len(modules))
+	for _, module := range modules {
+		policyNames = append(policyNames, module.Name)
+	}
+	summary := map[string]interface{}{
+		"policies":    policyNames,
+		"violations":  policyResults(violations),
+		"warnings":    policyResults(warnings),
+		"errors":      policyResults(errs),
+		"evaluatedAt": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if len(blocking) > 0 {
+		message := fmt.Sprintf("Apply blocked by %d broken policies: %s", len(blocking), strings.Join(blocking, ", "))
+		return map[string]interface{}{
+			"state":      "Failed",
+			"message":    message,
+			"policy":     summary,
+			"conditions": []interface{}{kubernetes.NewCondition("PolicyCompliant", "False", "PolicyError", message)},
+		}, true
+	}
+
+	if len(violations) > 0 {
+		messages := make([]string, 0, len(violations))
+		for _, violation := range violations {
+			messages = append(messages, violation.Message)
+		}
+		message := fmt.Sprintf("Apply blocked by %d policy violations: %s", len(violations), strings.Join(messages, "; "))
+		return map[string]interface{}{
+			"state":      "Failed",
+			"message":    message,
+			"policy":     summary,
+			"conditions": []interface{}{kubernetes.NewCondition("PolicyCompliant", "False", "PolicyViolation", message)},
+		}, true
+	}
+
+	message := "All policies passed"
+	if len(warnings) > 0 {
+		message = fmt.Sprintf("All policies passed with %d warnings", len(warnings))
+	}
+	if len(errs) > 0 {
+		message = fmt.Sprintf("%s, %d broken policies of the controller namespace skipped", message, len(errs))
+	}
+	return map[string]interface{}{
+		"state":      "Progressing",
+		"message":    message,
+		"policy":     summary,
+		"conditions": []interface{}{kubernetes.NewCondition("PolicyCompliant", "True", "PoliciesPassed", message)},
+	}, false
+}
+
+// loadPolicies reads the Rego modules of the policy ConfigMaps of the controller namespace and
+// of those selected in the namespace of the resource, named <namespace>/<configmap>/<key>.
+func (c *Controller) loadPolicies(observed SyncRequest) ([]policy.Module, error) {
+	namespace := observed.Parent.Metadata.Namespace
+	defaultSelector := labels.SelectorFromSet(labels.Set{policyLabel: "true"}).String()
+	selector := defaultSelector
+	if observed.Parent.Spec.Policy != nil && len(observed.Parent.Spec.Policy.Selector) > 0 {
+		selector = labels.SelectorFromSet(observed.Parent.Spec.Policy.Selector).String()
+	}
+
+	sources := []struct{ namespace, selector string }{{util.GetControllerNamespace(), defaultSelector}}
+	if namespace != util.GetControllerNamespace() || selector != defaultSelector {
+		sources = append(sources, struct{ namespace, selector string }{namespace, selector})
+	}
+
+	var modules []policy.Module
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		configMaps, err := c.clientset.CoreV1().ConfigMaps(source.namespace).List(context.Background(), metav1.ListOptions{LabelSelector: source.selector})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list policy ConfigMaps in %s: %v", source.namespace, err)
+		}
+		for _, configMap := range configMaps.Items {
+			for key, content := range configMap.Data {
+				moduleName := fmt.Sprintf("%s/%s/%s", source.namespace, configMap.Name, key)
+				if !strings.HasSuffix(key, ".rego") || seen[moduleName] {
+					continue
+				}
+				seen[moduleName] = true
+				modules = append(modules, policy.Module{Name: moduleName, Source: content})
+			}
+		}
+	}
+	return modules, nil
+}
+
+// sharedPolicy reports whether a policy module comes from the controller namespace and applies
+// to resources of other namespaces as well.
+func sharedPolicy(observed SyncRequest, moduleName string) bool {
+	controllerNamespace := util.GetControllerNamespace()
+	return observed.Parent.Metadata.Namespace != controllerNamespace && strings.HasPrefix(moduleName, controllerNamespace+"/")
+}
+
+// policyResults converts results to status values.
+func policyResults(results []policy.Result) []interface{} {
+	values := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		values = append(values, map[string]interface{}{
+			"policy":   result.Policy,
+			"severity": result.Severity,
+			"message":  result.Message,
+		})
+	}
+	return values
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// queueItem identifies a Terraform resource and the kind of run it is queued for.
+type queueItem struct {
+	Namespace  string
+	Name       string
+	DriftCheck bool
+}
+
+// enqueue adds an apply of a Terraform resource to the work queue. Items already waiting in the
+// queue are deduplicated, so repeated triggers for the same resource collapse into one run.
+func (c *Controller) enqueue(namespace, name string) {
+	log.Printf("Enqueuing Terraform resource %s/%s", namespace, name)
+	c.queue.Add(queueItem{Namespace: namespace, Name: name})
+}
+
+// enqueueDriftCheck adds a plan-only drift check of a Terraform resource to the work queue.
+func (c *Controller) enqueueDriftCheck(namespace, name string) {
+	log.Printf("Enqueuing drift check for Terraform resource %s/%s", namespace, name)
+	c.queue.Add(queueItem{Namespace: namespace, Name: name, DriftCheck: true})
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *Controller) processNextItem() bool {
+	item, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	if err := c.syncResource(item.(queueItem)); err != nil {
+		log.Printf("Error syncing %+v: %v", item, err)
+		c.queue.AddRateLimited(item)
+		return true
+	}
+
+	c.queue.Forget(item)
+	return true
+}
+
+// syncResource fetches the latest version of the queued resource and runs it.
+func (c *Controller) syncResource(queued queueItem) error {
+	item, err := c.dynClient.Resource(terraformGVR).Namespace(queued.Namespace).Get(context.Background(), queued.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get Terraform resource: %v", err)
+	}
+
+	// Deletion is driven by the metacontroller finalize hook
+	if item.GetDeletionTimestamp() != nil {
+		log.Printf("Terraform resource %s/%s is being deleted, skipping", queued.Namespace, queued.Name)
+		return nil
+	}
+
+	observed, err := syncRequestFromUnstructured(item)
+	if err != nil {
+		return err
+	}
+
+	if queued.DriftCheck {
+		log.Printf("Checking drift of resource: %s", observed.Parent.Metadata.Name)
+		c.handleDriftCheck(observed)
+		return nil
+	}
+
+	log.Printf("Handling resource: %s", observed.Parent.Metadata.Name)
+	c.handleSyncRequest(observed, c.remoteHead(observed))
+	return nil
+}
+
+// syncRequestFromUnstructured converts a Terraform resource read from the API into a SyncRequest.
+func syncRequestFromUnstructured(item *unstructured.Unstructured) (SyncRequest, error) {
+	var observed SyncRequest
+	raw, err := item.MarshalJSON()
+	if err != nil {
+		return observed, fmt.Errorf("error marshalling item: %v", err)
+	}
+	err = json.Unmarshal(raw, &observed.Parent)
+	if err != nil {
+		return observed, fmt.Errorf("error unmarshalling item: %v", err)
+	}
+	observed.Finalizing = item.GetDeletionTimestamp() != nil
+	return observed, nil
+}
@@ -0,0 +1,372 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/kubernetes"
+	"github.com/alustan/terraform-controller/pkg/terraform"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var terraformRunGVR = schema.GroupVersionResource{
+	Group:    "alustan.io",
+	Version:  "v1alpha1",
+	Resource: "terraformruns",
+}
+
+// States of a TerraformRun.
+const (
+	runRunning   = "Running"
+	runSucceeded = "Succeeded"
+	runFailed    = "Failed"
+	runCancelled = "Cancelled"
+)
+
+// runPollInterval is how often the pod of a TerraformRun apply is checked.
+const runPollInterval = 10 * time.Second
+
+// TerraformRunSpec requests a one-shot operation on a Terraform resource of the same namespace.
+type TerraformRunSpec struct {
+	Terraform   string   `json:"terraform"`
+	Operation   string   `json:"operation,omitempty"`
+	Targets     []string `json:"targets,omitempty"`
+	Replace     []string `json:"replace,omitempty"`
+	RefreshOnly bool     `json:"refreshOnly,omitempty"`
+}
+
+type TerraformRunResource struct {
+	ApiVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   metav1.ObjectMeta      `json:"metadata"`
+	Spec       TerraformRunSpec       `json:"spec"`
+	Status     map[string]interface{} `json:"status,omitempty"`
+}
+
+type RunSyncRequest struct {
+	Parent TerraformRunResource `json:"parent"`
+}
+
+// operation returns the container operation of a run, an apply by default.
+func (spec TerraformRunSpec) operation() container.Operation {
+	operation := container.Operation{
+		Type:        spec.Operation,
+		Targets:     spec.Targets,
+		Replace:     spec.Replace,
+		RefreshOnly: spec.RefreshOnly,
+	}
+	if operation.Type == "" {
+		operation.Type = container.OperationApply
+	}
+	return operation
+}
+
+// HandleRunSync executes a TerraformRun once. The operation runs in the background, later
+// syncs return its status until it finished, after which it is never run again. The request
+// only names the run: its spec and status are read from the API server, so a request that did
+// not come from Metacontroller cannot make up a run.
+func (c *Controller) HandleRunSync(r *gin.Context) {
+	var observed RunSyncRequest
+	if err := json.NewDecoder(r.Request.Body).Decode(&observed); err != nil {
+		r.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	defer func() {
+		if err := r.Request.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	namespace := observed.Parent.Metadata.Namespace
+	name := observed.Parent.Metadata.Name
+	item, err := c.dynClient.Resource(terraformRunGVR).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		r.String(http.StatusNotFound, fmt.Sprintf("terraformrun %s/%s not found", namespace, name))
+		return
+	}
+	if err != nil {
+		r.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	raw, err := item.MarshalJSON()
+	if err != nil {
+		r.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	var run TerraformRunResource
+	if err := json.Unmarshal(raw, &run); err != nil {
+		r.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	r.JSON(http.StatusOK, gin.H{"body": c.startRun(run)})
+}
+
+// startRun starts the operation of a TerraformRun unless it already ran or is running, and
+// returns its status.
+func (c *Controller) startRun(run TerraformRunResource) map[string]interface{} {
+	namespace := run.Metadata.Namespace
+	key := fmt.Sprintf("%s/%s", namespace, run.Metadata.Name)
+
+	switch statusString(run.Status, "state") {
+	case runSucceeded, runFailed, runCancelled:
+		return run.Status
+	}
+
+	c.runsMu.Lock()
+	defer c.runsMu.Unlock()
+	if c.runs[key] {
+		return run.Status
+	}
+
+	// A run still marked running that this controller does not know was interrupted by a restart
+	if statusString(run.Status, "state") == runRunning {
+		status := map[string]interface{}{
+			"state":       runFailed,
+			"message":     "The run was interrupted by a controller restart, create a new TerraformRun to retry",
+			"completedAt": time.Now().UTC().Format(time.RFC3339),
+		}
+		c.updateRunStatus(run, status)
+		return status
+	}
+
+	status := map[string]interface{}{
+		"state":     runRunning,
+		"message":   fmt.Sprintf("Running %s on %s", run.Spec.operation().Type, run.Spec.Terraform),
+		"startedAt": time.Now().UTC().Format(time.RFC3339),
+	}
+	c.updateRunStatus(run, status)
+
+	c.runs[key] = true
+	go func() {
+		result := c.executeRun(run)
+		result["completedAt"] = time.Now().UTC().Format(time.RFC3339)
+		c.updateRunStatus(run, result)
+
+		c.runsMu.Lock()
+		delete(c.runs, key)
+		c.runsMu.Unlock()
+	}()
+	return status
+}
+
+// executeRun runs the operation of a TerraformRun with the last image built for its Terraform
+// resource and returns its final status. The run pod is labelled like the pods of the resource,
+// so it never runs concurrently with an apply, destroy or drift check of the resource.
+func (c *Controller) executeRun(run TerraformRunResource) map[string]interface{} {
+	namespace := run.Metadata.Namespace
+	operation := run.Spec.operation()
+	failed := func(format string, args ...interface{}) map[string]interface{} {
+		message := fmt.Sprintf(format, args...)
+		log.Printf("TerraformRun %s/%s failed: %s", namespace, run.Metadata.Name, message)
+		return map[string]interface{}{"state": runFailed, "message": message}
+	}
+
+	if err := operation.Validate(); err != nil {
+		return failed("%v", err)
+	}
+
+	item, err := c.dynClient.Resource(terraformGVR).Namespace(namespace).Get(context.Background(), run.Spec.Terraform, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return failed("terraform %s/%s not found", namespace, run.Spec.Terraform)
+	}
+	if err != nil {
+		return failed("error reading terraform %s: %v", run.Spec.Terraform, err)
+	}
+	observed, err := syncRequestFromUnstructured(item)
+	if err != nil {
+		return failed("%v", err)
+	}
+	spec := observed.Parent.Spec
+	name := observed.Parent.Metadata.Name
+
+	switch {
+	case observed.Finalizing:
+		return failed("terraform %s is being deleted", name)
+	case spec.Suspend:
+		return failed("terraform %s is suspended", name)
+	case runnerName(spec) == RunnerTerragrunt:
+		return failed("TerraformRuns are not supported with the terragrunt runner")
+	}
+
+	// Applies only run inside the change windows of the resource and outside of freezes
+	if operation.Type == container.OperationApply {
+		if deferred, _, _ := c.changeWindowDeferral(observed, time.Now()); deferred != nil {
+			return failed("%v, create a new TerraformRun once changes are allowed", deferred["message"])
+		}
+	}
+
+	taggedImageName, err := c.getTaggedImageNameFromConfigMap(namespace, name)
+	if err != nil {
+		return failed("terraform %s has no built image yet: %v", name, err)
+	}
+	dependencies, err := c.dependencyEnvVars(observed)
+	if err != nil {
+		return failed("error reading dependency outputs: %v", err)
+	}
+	inputs, err := c.runPodInputs(observed, builtCommit(observed), dependencies.Sensitive)
+	if err != nil {
+		return failed("error rendering variables: %v", err)
+	}
+	defer c.releaseRunPodInputs(namespace, inputs)
+	envVars := mergeEnvVars(c.runEnvVars(spec), dependencies.EnvVars)
+	secretName := fmt.Sprintf("%s-container-secret", name)
+
+	podName, err := container.CreateRunPod(c.clientset, name, namespace, "", envVars, taggedImageName, secretName, operation.Command(runnerOf(spec)), inputs)
+	if err != nil {
+		return failed("failed to create run pod: %v", err)
+	}
+	c.updateRunStatus(run, map[string]interface{}{"podName": podName})
+
+	status := map[string]interface{}{"podName": podName}
+	if operation.Type == container.OperationPlan {
+		plan, err := container.WaitForPlan(c.clientset, namespace, podName)
+		if requestedBy, cancelled := c.takeCancellation(namespace, name); cancelled {
+			return cancelledRunStatus(status, requestedBy)
+		}
+		if err != nil {
+			return failed("failed to plan: %v", err)
+		}
+		summary, err := terraform.SummarizePlan(plan.JSON)
+		if err != nil {
+			return failed("%v", err)
+		}
+		status["state"] = runSucceeded
+		status["message"] = planMessage(summary)
+		status["plan"] = planStatus(summary, nil)
+		return status
+	}
+
+	// The apply pod plans first and applies the plan once it passed the policies and the
+	// destructive change policy of the resource, and the pre-apply hooks succeeded
+	if blocked := c.evaluateRunPlan(run, observed, podName, status); blocked != nil {
+		c.deletePod(namespace, podName)
+		return blocked
+	}
+	var hooks []interface{}
+	if len(spec.Hooks.PreApply) > 0 {
+		c.updateRunStatus(run, map[string]interface{}{"message": fmt.Sprintf("Running %s hooks", hookPreApply)})
+		var hookErr error
+		hooks, hookErr = c.runGatedPreHooks(observed, podName, spec.Hooks.PreApply, taggedImageName, secretName, envVars, inputs)
+		if requestedBy, cancelled := c.takeCancellation(namespace, name); cancelled {
+			c.deletePod(namespace, podName)
+			return cancelledRunStatus(status, requestedBy)
+		}
+		status["hooks"] = hooks
+		if hookErr != nil {
+			c.deletePod(namespace, podName)
+			status["state"] = runFailed
+			status["message"] = hookErr.Error()
+			return status
+		}
+	}
+	if err := container.SendVerdict(c.clientset, c.restConfig, namespace, podName); err != nil {
+		c.deletePod(namespace, podName)
+		return failed("failed to apply the plan of pod %s: %v", podName, err)
+	}
+	// The values of the sensitive outputs are only stored by the applies of the resource, the
+	// pod waits for them to be read before it ends
+	if _, err := container.WaitForSensitiveOutputs(c.clientset, c.restConfig, namespace, podName); err != nil {
+		log.Printf("Error reading sensitive outputs of run pod %s: %v", podName, err)
+	}
+
+	output, err := container.WaitForPodCompletionEvery(c.clientset, namespace, podName, runPollInterval)
+	if requestedBy, cancelled := c.takeCancellation(namespace, name); cancelled {
+		return cancelledRunStatus(status, requestedBy)
+	}
+	if err != nil {
+		status["state"] = runFailed
+		status["message"] = fmt.Sprintf("failed to apply: %v", err)
+		return status
+	}
+	status["output"], _ = runnerOf(spec).Outputs(output)
+
+	if len(spec.Hooks.PostApply) > 0 {
+		c.updateRunStatus(run, map[string]interface{}{"message": fmt.Sprintf("Running %s hooks", hookPostApply)})
+		postHooks, hookErr := c.runHookPod(observed, hookPostApply, spec.Hooks.PostApply, taggedImageName, secretName, envVars, inputs)
+		status["hooks"] = appendHooks(hooks, postHooks)
+		if hookErr != nil {
+			status["state"] = runFailed
+			status["message"] = fmt.Sprintf("Terraform applied successfully, but %v", hookErr)
+			return status
+		}
+	}
+	status["state"] = runSucceeded
+	status["message"] = "Terraform applied successfully"
+	return status
+}
+
+// evaluateRunPlan waits for the plan of the apply pod of a TerraformRun, records it in the
+// status of the run and returns the final status of the run if its policies or the destructive
+// change policy of the resource block it. Approvals are given on the Terraform resource and
+// hold for the commit of its last built image.
+func (c *Controller) evaluateRunPlan(run TerraformRunResource, observed SyncRequest, podName string, status map[string]interface{}) map[string]interface{} {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+	blocked := func(result map[string]interface{}) map[string]interface{} {
+		for field, value := range result {
+			if field != "state" && field != "conditions" {
+				status[field] = value
+			}
+		}
+		status["state"] = runFailed
+		return status
+	}
+
+	output, err := container.WaitForGate(c.clientset, namespace, podName)
+	if requestedBy, cancelled := c.takeCancellation(namespace, name); cancelled {
+		return cancelledRunStatus(status, requestedBy)
+	}
+	if err != nil {
+		return blocked(map[string]interface{}{"message": fmt.Sprintf("failed to plan: %v", err)})
+	}
+	summary, err := terraform.SummarizePlan(output.JSON)
+	if err != nil {
+		return blocked(map[string]interface{}{"message": err.Error()})
+	}
+	status["plan"] = planStatus(summary, nil)
+	c.updateRunStatus(run, map[string]interface{}{"message": planMessage(summary), "plan": status["plan"]})
+
+	if estimate := c.estimateCost(output.JSON); estimate != nil {
+		// Policies read the estimate as input.cost
+		output.JSON["cost"] = estimate
+		status["cost"] = estimate
+	}
+
+	if result, denied := c.evaluatePolicies(observed, output.JSON, true); denied {
+		return blocked(result)
+	} else if result != nil {
+		status["policy"] = result["policy"]
+	}
+
+	commit := statusString(observed.Parent.Status, "lastAttemptedCommit")
+	if _, result := checkDestructiveChanges(observed, commit, summary); result != nil {
+		if _, pending := result["pendingApproval"]; pending {
+			result["message"] = fmt.Sprintf("%v, then create a new TerraformRun", result["message"])
+		}
+		return blocked(result)
+	}
+	return nil
+}
+
+func cancelledRunStatus(status map[string]interface{}, requestedBy string) map[string]interface{} {
+	status["state"] = runCancelled
+	status["message"] = fmt.Sprintf("Run cancelled by %s", requestedBy)
+	return status
+}
+
+// updateRunStatus merges fields into the status of a TerraformRun.
+func (c *Controller) updateRunStatus(run TerraformRunResource, fields map[string]interface{}) {
+	err := kubernetes.MergeResourceStatus(c.dynClient, terraformRunGVR, run.Metadata.Namespace, run.Metadata.Name, fields)
+	if err != nil {
+		log.Printf("Error updating status of TerraformRun %s/%s: %v", run.Metadata.Namespace, run.Metadata.Name, err)
+	}
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/util"
+)
+
+// Runners of a resource.
+const (
+	RunnerScript     = "script"
+	RunnerTerragrunt = "terragrunt"
+	RunnerBuiltin    = "builtin"
+)
+
+// runnerName returns the runner of a resource. Without spec.runner, resources with deploy or
+// destroy scripts keep running them and the others use the builtin runner.
+func runnerName(spec TerraformConfigSpec) string {
+	if spec.Runner != "" {
+		return spec.Runner
+	}
+	if spec.Scripts.Deploy != "" || spec.Scripts.Destroy != "" {
+		return RunnerScript
+	}
+	return RunnerBuiltin
+}
+
+// runnerOf returns the runner of a resource.
+func runnerOf(spec TerraformConfigSpec) container.Runner {
+	switch runnerName(spec) {
+	case RunnerTerragrunt:
+		runner := container.Runner{Terragrunt: true}
+		if spec.Terragrunt != nil {
+			runner.RunAll = spec.Terragrunt.RunAll
+		}
+		return runner
+	case RunnerBuiltin:
+		return container.Runner{Builtin: true, GenerateConfig: spec.GenerateImportConfig}
+	}
+	return container.Runner{GenerateConfig: spec.GenerateImportConfig}
+}
+
+// runnerImage returns the image the builtin runner is copied from, empty when a resource does
+// not use it.
+func runnerImage(spec TerraformConfigSpec) string {
+	if runnerName(spec) != RunnerBuiltin {
+		return ""
+	}
+	return util.GetRunnerImage()
+}
+
+// validateRunner rejects the settings the runner of a resource cannot honour. Terragrunt
+// configures the state of its units itself with remote_state blocks.
+func validateRunner(spec TerraformConfigSpec) error {
+	runner := runnerName(spec)
+	if spec.Terragrunt != nil && runner != RunnerTerragrunt {
+		return fmt.Errorf("terragrunt is only supported with the terragrunt runner")
+	}
+
+	switch runner {
+	case RunnerScript:
+		// The script runner runs the scripts as they are
+	case RunnerTerragrunt:
+		if spec.Backend != nil {
+			return fmt.Errorf("backend is not supported with the terragrunt runner, configure remote_state in terragrunt.hcl")
+		}
+		if spec.Workspace != "" {
+			return fmt.Errorf("workspace is not supported with the terragrunt runner")
+		}
+		// Generated files are written to the working directory, which is not a unit when running all units
+		if spec.Terragrunt != nil && spec.Terragrunt.RunAll {
+			if len(spec.Vars) > 0 || len(spec.VarFiles) > 0 || (spec.Sops != nil && len(spec.Sops.Files) > 0) {
+				return fmt.Errorf("vars, varFiles and sops are not supported with terragrunt runAll, use variables, variablesFrom or terragrunt inputs")
+			}
+			if len(spec.Imports) > 0 {
+				return fmt.Errorf("imports are not supported with terragrunt runAll, use import blocks in the units")
+			}
+		}
+	case RunnerBuiltin:
+		if spec.Scripts.Deploy != "" || spec.Scripts.Destroy != "" {
+			return fmt.Errorf("the builtin runner does not run the deploy and destroy scripts, use hooks")
+		}
+	default:
+		return fmt.Errorf("unsupported runner %q, expected script, terragrunt or builtin", spec.Runner)
+	}
+
+	// A runner that plans again before applying would not apply the plan whose changes were checked
+	if !runnerOf(spec).SavedPlan() && spec.DestructiveChangePolicy != "" && spec.DestructiveChangePolicy != DestructiveChangeAllow {
+		return fmt.Errorf("destructiveChangePolicy %s is not supported with the %s runner, use the builtin or terragrunt runner", spec.DestructiveChangePolicy, runner)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/util"
+)
+
+// nextApplyTime returns when the next periodic apply is due after lastRun. periodic is false
+// when the resource only declares a drift check schedule, which replaces the global sync interval.
+func (c *Controller) nextApplyTime(spec TerraformConfigSpec, lastRun time.Time) (next time.Time, periodic bool, err error) {
+	switch {
+	case spec.Schedule.Apply != "":
+		next, err = util.NextScheduleTime(spec.Schedule.Apply, spec.Schedule.TimeZone, lastRun)
+		return next, true, err
+	case spec.SyncInterval != "":
+		interval, err := time.ParseDuration(spec.SyncInterval)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid syncInterval %q: %v", spec.SyncInterval, err)
+		}
+		return lastRun.Add(interval), true, nil
+	case spec.Schedule.DriftCheck != "":
+		return time.Time{}, false, nil
+	}
+	return lastRun.Add(c.syncInterval), true, nil
+}
+
+// nextDriftCheckTime returns when the next drift check is due after lastCheck, if the resource schedules drift checks.
+func nextDriftCheckTime(spec TerraformConfigSpec, lastCheck time.Time) (time.Time, bool, error) {
+	if spec.Schedule.DriftCheck == "" {
+		return time.Time{}, false, nil
+	}
+	next, err := util.NextScheduleTime(spec.Schedule.DriftCheck, spec.Schedule.TimeZone, lastCheck)
+	return next, true, err
+}
+
+// lastDriftCheck returns the time of the last drift check, falling back to the last run.
+func lastDriftCheck(status map[string]interface{}) (time.Time, error) {
+	lastCheck := statusString(status, "lastDriftCheckTime")
+	if lastCheck == "" {
+		lastCheck = statusString(status, "lastRunTime")
+	}
+	return time.Parse(time.RFC3339, lastCheck)
+}
+
+// driftCheckDue reports whether the drift check schedule of the resource elapsed.
+func (c *Controller) driftCheckDue(observed SyncRequest, now time.Time) bool {
+	lastCheck, err := lastDriftCheck(observed.Parent.Status)
+	if err != nil {
+		return false
+	}
+	next, scheduled, err := nextDriftCheckTime(observed.Parent.Spec, lastCheck)
+	return err == nil && scheduled && !now.Before(next)
+}
+
+// nextRunTimes returns the status fields reporting the next scheduled apply and drift check
+// for a run starting at now.
+func (c *Controller) nextRunTimes(observed SyncRequest, now time.Time) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	nextApply, periodic, err := c.nextApplyTime(observed.Parent.Spec, now)
+	if err != nil {
+		return nil, err
+	}
+	if periodic {
+		fields["nextApplyTime"] = nextApply.UTC().Format(time.RFC3339)
+	}
+
+	lastCheck, err := lastDriftCheck(observed.Parent.Status)
+	if err != nil {
+		lastCheck = now
+	}
+	nextCheck, scheduled, err := nextDriftCheckTime(observed.Parent.Spec, lastCheck)
+	if err != nil {
+		return nil, err
+	}
+	if scheduled {
+		fields["nextDriftCheckTime"] = nextCheck.UTC().Format(time.RFC3339)
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/alustan/terraform-controller/pkg/sops"
+	"github.com/alustan/terraform-controller/pkg/terraform"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Sops lists SOPS encrypted YAML or JSON files of the repository, relative to its root, whose
+// top-level keys are passed to Terraform as variables. The key Secret holds age identities in
+// entries ending in .agekey and armored PGP private keys in entries ending in .asc.
+type Sops struct {
+	Files        []string        `json:"files"`
+	KeySecretRef SecretReference `json:"keySecretRef"`
+}
+
+// SecretReference names a Secret in the namespace of the resource.
+type SecretReference struct {
+	Name string `json:"name"`
+}
+
+var invalidSecretKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// sopsInputs decrypts the SOPS files of a resource at the commit the run pod image was built
+// from in the controller and returns the run pod inputs exposing them as tfvars files. Without
+// SOPS files, stale decrypted files are removed.
+func (c *Controller) sopsInputs(observed SyncRequest, commit string) (container.RunPodInputs, error) {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+	spec := observed.Parent.Spec
+
+	if spec.Sops == nil || len(spec.Sops.Files) == 0 {
+		return container.RunPodInputs{}, container.DeleteSopsSecrets(c.clientset, name, namespace)
+	}
+
+	keySecret, err := c.clientset.CoreV1().Secrets(namespace).Get(context.Background(), spec.Sops.KeySecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return container.RunPodInputs{}, fmt.Errorf("failed to get sops key Secret %s: %v", spec.Sops.KeySecretRef.Name, err)
+	}
+	keys, err := sops.ParseKeys(keySecret.Data)
+	if err != nil {
+		return container.RunPodInputs{}, fmt.Errorf("invalid sops key Secret %s: %v", spec.Sops.KeySecretRef.Name, err)
+	}
+
+	encrypted, err := terraform.ReadRepoFiles(spec.GitRepo.URL, spec.GitRepo.Branch, commit, os.Getenv("GIT_SSH_SECRET"), spec.Sops.Files)
+	if err != nil {
+		return container.RunPodInputs{}, err
+	}
+
+	files := make(map[string][]byte, len(spec.Sops.Files))
+	fileNames := make([]string, 0, len(spec.Sops.Files))
+	for i, file := range spec.Sops.Files {
+		values, err := sops.Decrypt(encrypted[file], keys)
+		if err != nil {
+			return container.RunPodInputs{}, fmt.Errorf("failed to decrypt %s: %v", file, err)
+		}
+		content, err := json.Marshal(values)
+		if err != nil {
+			return container.RunPodInputs{}, fmt.Errorf("failed to render %s: %v", file, err)
+		}
+
+		base := path.Base(file)
+		base = invalidSecretKeyChars.ReplaceAllString(strings.TrimSuffix(base, path.Ext(base)), "-")
+		fileName := fmt.Sprintf("controller-sops-%02d-%s.auto.tfvars.json", i, base)
+		files[fileName] = content
+		fileNames = append(fileNames, fileName)
+	}
+
+	owner := metav1.OwnerReference{
+		APIVersion: observed.Parent.ApiVersion,
+		Kind:       observed.Parent.Kind,
+		Name:       name,
+		UID:        observed.Parent.Metadata.UID,
+	}
+	secretName, err := container.CreateSopsSecret(c.clientset, name, namespace, owner, files)
+	if err != nil {
+		return container.RunPodInputs{}, err
+	}
+	return container.SopsInputs(secretName, fileNames), nil
+}
+
+// builtCommit returns the commit the last image of a resource was built from, as recorded in
+// status.lastAttemptedCommit, or an empty string if it is unknown.
+func builtCommit(observed SyncRequest) string {
+	return statusString(observed.Parent.Status, "lastAttemptedCommit")
+}
+
+// releaseRunPodInputs deletes the Secrets created for the inputs of a run pod, so decrypted
+// SOPS files and sensitive dependency outputs are only stored while the pod runs.
+func (c *Controller) releaseRunPodInputs(namespace string, inputs container.RunPodInputs) {
+	if err := container.DeleteInputSecrets(c.clientset, namespace, inputs); err != nil {
+		log.Printf("Error releasing run pod inputs: %v", err)
+	}
+}
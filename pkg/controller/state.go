@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// stateCacheTTL is how long an inspected state is reused while the resource did not run again
+	stateCacheTTL = 10 * time.Minute
+	// stateWaitTimeout is how long a request waits for the inspection pod, which keeps running
+	// and fills the cache for the next request if it takes longer
+	stateWaitTimeout = 3 * time.Minute
+	// statePollInterval is how often the inspection pod is checked for completion
+	statePollInterval = 5 * time.Second
+)
+
+// StateSummary lists what a Terraform resource owns according to its current state.
+type StateSummary struct {
+	TerraformVersion string                 `json:"terraformVersion,omitempty"`
+	Workspace        string                 `json:"workspace,omitempty"`
+	Resources        []StateResource        `json:"resources"`
+	Outputs          map[string]StateOutput `json:"outputs"`
+	FetchedAt        string                 `json:"fetchedAt"`
+}
+
+// StateResource is a resource of the state. Module is empty for the root module.
+type StateResource struct {
+	Address  string `json:"address"`
+	Mode     string `json:"mode"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Module   string `json:"module,omitempty"`
+}
+
+// StateOutput is an output of the state. Sensitive values are redacted.
+type StateOutput struct {
+	Value     interface{} `json:"value"`
+	Type      interface{} `json:"type,omitempty"`
+	Sensitive bool        `json:"sensitive"`
+}
+
+// stateInspection is a state inspection of a resource, in progress until done is closed.
+type stateInspection struct {
+	version   string
+	startedAt time.Time
+	done      chan struct{}
+	summary   *StateSummary
+	err       error
+}
+
+// showState is the part of the `terraform show -json` output used for the summary.
+type showState struct {
+	TerraformVersion string `json:"terraform_version"`
+	Values           *struct {
+		Outputs map[string]struct {
+			Sensitive bool        `json:"sensitive"`
+			Value     interface{} `json:"value"`
+			Type      interface{} `json:"type"`
+		} `json:"outputs"`
+		RootModule showModule `json:"root_module"`
+	} `json:"values"`
+}
+
+type showModule struct {
+	Address   string `json:"address"`
+	Resources []struct {
+		Address      string `json:"address"`
+		Mode         string `json:"mode"`
+		Type         string `json:"type"`
+		Name         string `json:"name"`
+		ProviderName string `json:"provider_name"`
+	} `json:"resources"`
+	ChildModules []showModule `json:"child_modules"`
+}
+
+// HandleState returns the resources and outputs of the current state of a Terraform resource
+// to callers allowed to get it.
+func (c *Controller) HandleState(r *gin.Context) {
+	namespace := r.Param("namespace")
+	name := r.Param("name")
+
+	if _, ok := c.authorize(r, "get", namespace, name); !ok {
+		return
+	}
+
+	item, err := c.dynClient.Resource(terraformGVR).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		r.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("terraform %s/%s not found", namespace, name)})
+		return
+	}
+	if err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	observed, err := syncRequestFromUnstructured(item)
+	if err != nil {
+		r.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	inspection := c.inspectState(observed)
+	select {
+	case <-inspection.done:
+	case <-time.After(stateWaitTimeout):
+		r.JSON(http.StatusGatewayTimeout, gin.H{"error": "state inspection is still running, retry later"})
+		return
+	}
+
+	if inspection.err != nil {
+		r.JSON(http.StatusBadGateway, gin.H{"error": inspection.err.Error()})
+		return
+	}
+	r.JSON(http.StatusOK, inspection.summary)
+}
+
+// inspectState returns the state inspection of a resource, reusing the one in progress or a
+// recent one made since the resource last ran, or starting a new one.
+func (c *Controller) inspectState(observed SyncRequest) *stateInspection {
+	key := fmt.Sprintf("%s/%s", observed.Parent.Metadata.Namespace, observed.Parent.Metadata.Name)
+	version := statusString(observed.Parent.Status, "lastRunTime")
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if inspection, found := c.stateInspections[key]; found {
+		select {
+		case <-inspection.done:
+			if inspection.err == nil && inspection.version == version && time.Since(inspection.startedAt) < stateCacheTTL {
+				return inspection
+			}
+		default:
+			return inspection
+		}
+	}
+
+	inspection := &stateInspection{version: version, startedAt: time.Now(), done: make(chan struct{})}
+	c.stateInspections[key] = inspection
+	go func() {
+		defer close(inspection.done)
+		inspection.summary, inspection.err = c.showState(observed)
+		if inspection.err != nil {
+			log.Printf("Error inspecting state of %s: %v", key, inspection.err)
+		}
+	}()
+	return inspection
+}
+
+// showState summarizes `terraform show -json` in a short-lived pod using the last applied image,
+// deleted once its summary was read.
+func (c *Controller) showState(observed SyncRequest) (*StateSummary, error) {
+	name := observed.Parent.Metadata.Name
+	namespace := observed.Parent.Metadata.Namespace
+	secretName := fmt.Sprintf("%s-container-secret", name)
+
+	taggedImageName, err := c.getTaggedImageNameFromConfigMap(namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("no applied image: %v", err)
+	}
+	dependencies, err := c.dependencyEnvVars(observed)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := c.runPodInputs(observed, builtCommit(observed), dependencies.Sensitive)
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseRunPodInputs(namespace, inputs)
+
+	podName, err := container.CreateInspectionPod(c.clientset, name, namespace, mergeEnvVars(c.runEnvVars(observed.Parent.Spec), dependencies.EnvVars), taggedImageName, secretName, runnerOf(observed.Parent.Spec).ShowStateCommand(), inputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state inspection pod: %v", err)
+	}
+	defer func() {
+		if err := container.DeletePod(c.clientset, namespace, podName); err != nil {
+			log.Printf("Error deleting state inspection pod: %v", err)
+		}
+	}()
+	output, err := container.WaitForPodCompletionEvery(c.clientset, namespace, podName, statePollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+
+	summary, err := summarizeState(output)
+	if err != nil {
+		return nil, err
+	}
+	summary.Workspace = observed.Parent.Spec.Workspace
+	return summary, nil
+}
+
+// summarizeState reads the state summary printed by the inspection pod, redacting sensitive outputs.
+func summarizeState(output map[string]interface{}) (*StateSummary, error) {
+	raw, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	var state showState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("invalid state: %v", err)
+	}
+
+	summary := &StateSummary{
+		TerraformVersion: state.TerraformVersion,
+		Resources:        []StateResource{},
+		Outputs:          make(map[string]StateOutput),
+		FetchedAt:        time.Now().UTC().Format(time.RFC3339),
+	}
+	if state.Values == nil {
+		return summary, nil
+	}
+
+	for name, output := range state.Values.Outputs {
+		value := output.Value
+		if output.Sensitive {
+			value = "(sensitive value)"
+		}
+		summary.Outputs[name] = StateOutput{Value: value, Type: output.Type, Sensitive: output.Sensitive}
+	}
+
+	var collect func(module showModule)
+	collect = func(module showModule) {
+		for _, resource := range module.Resources {
+			summary.Resources = append(summary.Resources, StateResource{
+				Address:  resource.Address,
+				Mode:     resource.Mode,
+				Type:     resource.Type,
+				Name:     resource.Name,
+				Provider: resource.ProviderName,
+				Module:   module.Address,
+			})
+		}
+		for _, child := range module.ChildModules {
+			collect(child)
+		}
+	}
+	collect(state.Values.RootModule)
+	return summary, nil
+}
@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"github.com/alustan/terraform-controller/pkg/container"
+)
+
+// Terraform selects the Terraform or OpenTofu release installed in the image of a resource,
+// Terraform 1.8.1 by default. OpenTofu is also available as terraform in the image.
+type Terraform struct {
+	Distribution string `json:"distribution,omitempty"`
+	Version      string `json:"version,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+}
+
+// terraformBinary returns the release installed in the image of a resource.
+func terraformBinary(spec TerraformConfigSpec) container.TerraformBinary {
+	if spec.Terraform == nil {
+		return container.TerraformBinary{}.Resolved()
+	}
+	return container.TerraformBinary{
+		Distribution: spec.Terraform.Distribution,
+		Version:      spec.Terraform.Version,
+		SHA256:       spec.Terraform.SHA256,
+	}.Resolved()
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"github.com/alustan/terraform-controller/pkg/container"
+)
+
+// Terragrunt configures the Terragrunt runner. With RunAll every unit below the working
+// directory is run with `terragrunt run-all`, otherwise the working directory is a single unit.
+type Terragrunt struct {
+	Version string `json:"version,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	RunAll  bool   `json:"runAll,omitempty"`
+}
+
+// terragruntBinary returns the Terragrunt release installed in the image of a resource, nil
+// when it does not use the Terragrunt runner.
+func terragruntBinary(spec TerraformConfigSpec) *container.TerragruntBinary {
+	if runnerName(spec) != RunnerTerragrunt {
+		return nil
+	}
+	binary := container.TerragruntBinary{}
+	if spec.Terragrunt != nil {
+		binary.Version = spec.Terragrunt.Version
+		binary.SHA256 = spec.Terragrunt.SHA256
+	}
+	binary = binary.Resolved()
+	return &binary
+}
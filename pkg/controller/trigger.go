@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/terraform"
+)
+
+// reconcileAtAnnotation forces an immediate run whenever its value changes.
+const reconcileAtAnnotation = "alustan.io/reconcile-at"
+
+// runReason returns why a resource needs an apply, or an empty string when its spec and
+// commit were already handled and no scheduled apply is due yet.
+func (c *Controller) runReason(observed SyncRequest, remoteCommit string, now time.Time) string {
+	status := observed.Parent.Status
+
+	if requestedAt := observed.Parent.Metadata.Annotations[reconcileAtAnnotation]; requestedAt != "" && requestedAt != statusString(status, "lastHandledReconcileAt") {
+		return fmt.Sprintf("reconcile requested at %s", requestedAt)
+	}
+
+	if approvalGranted(observed) {
+		return "destructive changes approved"
+	}
+
+	if observed.Parent.Metadata.Generation != statusInt64(status, "observedGeneration") {
+		return "spec changed"
+	}
+
+	// Compare against the last attempted commit so a failing commit is not retried on every poll
+	if remoteCommit != "" && remoteCommit != statusString(status, "lastAttemptedCommit") {
+		return fmt.Sprintf("new commit %s", remoteCommit)
+	}
+
+	lastRun, err := time.Parse(time.RFC3339, statusString(status, "lastRunTime"))
+	if err != nil {
+		return "no previous run"
+	}
+
+	next, periodic, err := c.nextApplyTime(observed.Parent.Spec, lastRun)
+	if err != nil {
+		log.Printf("Error evaluating schedule of %s: %v", observed.Parent.Metadata.Name, err)
+		return ""
+	}
+	if periodic && !now.Before(next) {
+		return "scheduled apply due"
+	}
+
+	return ""
+}
+
+// remoteHeads are the branch heads of a repository as polled at polledAt, nil if polling failed.
+type remoteHeads struct {
+	branches map[string]string
+	polledAt time.Time
+}
+
+// remoteHead returns the current commit of the tracked branch, or an empty string if it cannot be determined.
+func (c *Controller) remoteHead(observed SyncRequest) string {
+	gitRepo := observed.Parent.Spec.GitRepo
+	return c.remoteBranches(gitRepo.URL)[gitRepo.Branch]
+}
+
+// remoteBranches returns the branch heads of a repository. They are polled at most once per poll
+// interval and shared by all resources tracking the repository, until a webhook reports a push.
+func (c *Controller) remoteBranches(url string) map[string]string {
+	key := normalizeRepoURL(url)
+	c.headsMu.Lock()
+	heads, polled := c.remoteHeads[key]
+	c.headsMu.Unlock()
+	if polled && time.Since(heads.polledAt) < c.pollInterval {
+		return heads.branches
+	}
+
+	branches, err := terraform.ListRemoteBranches(url, os.Getenv("GIT_SSH_SECRET"))
+	if err != nil {
+		log.Printf("Error polling %s: %v", url, err)
+	}
+	c.headsMu.Lock()
+	c.remoteHeads[key] = remoteHeads{branches: branches, polledAt: time.Now()}
+	c.headsMu.Unlock()
+	return branches
+}
+
+// forgetRemoteBranches discards the polled branch heads of a pushed repository, given as a
+// normalized URL, so the resources it enqueues run the pushed commit.
+func (c *Controller) forgetRemoteBranches(repo string) {
+	c.headsMu.Lock()
+	defer c.headsMu.Unlock()
+	delete(c.remoteHeads, repo)
+}
+
+func statusString(status map[string]interface{}, key string) string {
+	value, _ := status[key].(string)
+	return value
+}
+
+func statusInt64(status map[string]interface{}, key string) int64 {
+	switch value := status[key].(type) {
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	}
+	return 0
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alustan/terraform-controller/pkg/container"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VariablesFrom injects variables from a ConfigMap or a Secret in the namespace of the resource.
+// Without keys every key of the object becomes an environment variable, prefixed with prefix.
+type VariablesFrom struct {
+	ConfigMapRef *VariablesRef `json:"configMapRef,omitempty"`
+	SecretRef    *VariablesRef `json:"secretRef,omitempty"`
+	Prefix       string        `json:"prefix,omitempty"`
+}
+
+// VariablesRef names a ConfigMap or Secret and optionally selects some of its keys.
+type VariablesRef struct {
+	Name     string        `json:"name"`
+	Keys     []VariableKey `json:"keys,omitempty"`
+	Optional bool          `json:"optional,omitempty"`
+}
+
+// VariableKey maps a key of a ConfigMap or Secret to an environment variable, named after the key by default.
+type VariableKey struct {
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"`
+}
+
+// runPodInputs returns the inputs shared by all run pods of a resource: its variables from
+// ConfigMaps and Secrets, its var files, its rendered vars, its decrypted SOPS files, its
+// backend and its workspace. Setups run in that order, so the workspace is selected last. The
+// SOPS files are read at the given commit, the one the run pod image was built from. The
+// sensitive outputs of the dependencies are set from a Secret created for the pod. Callers
+// release the inputs with releaseRunPodInputs once the run pod finished.
+func (c *Controller) runPodInputs(observed SyncRequest, commit string, sensitive map[string]string) (container.RunPodInputs, error) {
+	spec := observed.Parent.Spec
+
+	var configMapName string
+	if len(spec.Vars) > 0 {
+		content, err := json.MarshalIndent(spec.Vars, "", "  ")
+		if err != nil {
+			return container.RunPodInputs{}, fmt.Errorf("failed to render vars: %v", err)
+		}
+		configMapName, err = container.ApplyTfvarsConfigMap(c.clientset, observed.Parent.Metadata.Name, observed.Parent.Metadata.Namespace, string(content))
+		if err != nil {
+			return container.RunPodInputs{}, err
+		}
+	}
+
+	backendInputs, err := backendInputs(observed.Parent)
+	if err != nil {
+		return container.RunPodInputs{}, err
+	}
+
+	importInputs, err := importInputs(spec)
+	if err != nil {
+		return container.RunPodInputs{}, err
+	}
+
+	// Decrypted last, so no other error leaves the plaintext Secret behind
+	sopsInputs, err := c.sopsInputs(observed, commit)
+	if err != nil {
+		return container.RunPodInputs{}, err
+	}
+	dependencyInputs, err := c.dependencyInputs(observed, sensitive)
+	if err != nil {
+		c.releaseRunPodInputs(observed.Parent.Metadata.Namespace, sopsInputs)
+		return container.RunPodInputs{}, err
+	}
+
+	inputs := variablesFromInputs(spec).
+		With(container.TfvarsInputs(configMapName, spec.VarFiles)).
+		With(sopsInputs).
+		With(backendInputs).
+		With(container.WorkspaceInputs(spec.Workspace)).
+		With(importInputs).
+		With(dependencyInputs)
+	return inputs, nil
+}
+
+// variablesFromInputs returns the ConfigMap and Secret references of a run pod. Values are resolved
+// by the kubelet, so they never appear in the pod spec, the status or the controller logs.
+func variablesFromInputs(spec TerraformConfigSpec) container.RunPodInputs {
+	var inputs container.RunPodInputs
+	for _, source := range spec.VariablesFrom {
+		if ref := source.ConfigMapRef; ref != nil {
+			if len(ref.Keys) == 0 {
+				inputs.EnvFrom = append(inputs.EnvFrom, corev1.EnvFromSource{
+					Prefix: source.Prefix,
+					ConfigMapRef: &corev1.ConfigMapEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+						Optional:             &ref.Optional,
+					},
+				})
+			}
+			for _, key := range ref.Keys {
+				inputs.Env = append(inputs.Env, corev1.EnvVar{
+					Name: key.envName(source.Prefix),
+					ValueFrom: &corev1.EnvVarSource{
+						ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+							Key:                  key.Key,
+							Optional:             &ref.Optional,
+						},
+					},
+				})
+			}
+		}
+
+		if ref := source.SecretRef; ref != nil {
+			if len(ref.Keys) == 0 {
+				inputs.EnvFrom = append(inputs.EnvFrom, corev1.EnvFromSource{
+					Prefix: source.Prefix,
+					SecretRef: &corev1.SecretEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+						Optional:             &ref.Optional,
+					},
+				})
+			}
+			for _, key := range ref.Keys {
+				inputs.Env = append(inputs.Env, corev1.EnvVar{
+					Name: key.envName(source.Prefix),
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: ref.Name},
+							Key:                  key.Key,
+							Optional:             &ref.Optional,
+						},
+					},
+				})
+			}
+		}
+	}
+	return inputs
+}
+
+func (k VariableKey) envName(prefix string) string {
+	if k.Name != "" {
+		return k.Name
+	}
+	return prefix + k.Key
+}
+
+// checkVariableSources verifies that the referenced ConfigMaps and Secrets, their selected
+// keys and the backend credentials Secret exist. A run pod with a missing reference would otherwise never start.
+func (c *Controller) checkVariableSources(namespace string, spec TerraformConfigSpec) error {
+	if spec.Backend != nil && spec.Backend.CredentialsSecretRef != nil {
+		name := spec.Backend.CredentialsSecretRef.Name
+		if _, err := c.clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("failed to get backend credentials Secret %s: %v", name, err)
+		}
+	}
+
+	// An entry may reference both a ConfigMap and a Secret, each is checked on its own
+	for _, source := range spec.VariablesFrom {
+		if ref := source.ConfigMapRef; ref != nil {
+			if err := c.checkVariablesConfigMap(namespace, ref); err != nil {
+				return err
+			}
+		}
+		if ref := source.SecretRef; ref != nil {
+			if err := c.checkVariablesSecret(namespace, ref); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Controller) checkVariablesConfigMap(namespace string, ref *VariablesRef) error {
+	configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) && ref.Optional {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get variables ConfigMap %s: %v", ref.Name, err)
+	}
+	for _, key := range ref.Keys {
+		_, inData := configMap.Data[key.Key]
+		_, inBinaryData := configMap.BinaryData[key.Key]
+		if !inData && !inBinaryData && !ref.Optional {
+			return fmt.Errorf("key %s not found in variables ConfigMap %s", key.Key, ref.Name)
+		}
+	}
+	return nil
+}
+
+func (c *Controller) checkVariablesSecret(namespace string, ref *VariablesRef) error {
+	secret, err := c.clientset.CoreV1().Secrets(namespace).Get(context.Background(), ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) && ref.Optional {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get variables Secret %s: %v", ref.Name, err)
+	}
+	for _, key := range ref.Keys {
+		if _, found := secret.Data[key.Key]; !found && !ref.Optional {
+			return fmt.Errorf("key %s not found in variables Secret %s", key.Key, ref.Name)
+		}
+	}
+	return nil
+}
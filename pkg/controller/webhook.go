@@ -0,0 +1,382 @@
+package controller
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pushEvent is the provider independent view of a git push used to select Terraform resources.
+type pushEvent struct {
+	RepoURLs []string
+	Branch   string
+	// ChangedFiles is nil when the provider does not report the full list of changed files
+	ChangedFiles []string
+}
+
+type pushCommit struct {
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+type githubPushPayload struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+		SSHURL   string `json:"ssh_url"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Commits []pushCommit `json:"commits"`
+	Forced  bool         `json:"forced"`
+}
+
+type gitlabPushPayload struct {
+	Ref     string `json:"ref"`
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+		GitSSHURL  string `json:"git_ssh_url"`
+		WebURL     string `json:"web_url"`
+	} `json:"project"`
+	Commits           []pushCommit `json:"commits"`
+	TotalCommitsCount int          `json:"total_commits_count"`
+}
+
+type bitbucketLink struct {
+	Href string `json:"href"`
+}
+
+type bitbucketPushPayload struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+		Links    struct {
+			HTML  bitbucketLink   `json:"html"`
+			Clone []bitbucketLink `json:"clone"`
+		} `json:"links"`
+	} `json:"repository"`
+	// Bitbucket Cloud
+	Push struct {
+		Changes []struct {
+			New struct {
+				Type string `json:"type"`
+				Name string `json:"name"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	// Bitbucket Data Center
+	Changes []struct {
+		Ref struct {
+			Type      string `json:"type"`
+			DisplayID string `json:"displayId"`
+		} `json:"ref"`
+	} `json:"changes"`
+}
+
+// HandleGitHubWebhook handles GitHub push events signed with X-Hub-Signature-256.
+func (c *Controller) HandleGitHubWebhook(r *gin.Context) {
+	body, ok := c.readWebhookBody(r, "github")
+	if !ok {
+		return
+	}
+
+	if !validHMACSignature(c.webhookSecrets["github"], body, r.GetHeader("X-Hub-Signature-256")) {
+		r.String(http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	if event := r.GetHeader("X-GitHub-Event"); event != "push" {
+		r.String(http.StatusOK, fmt.Sprintf("ignoring %s event", event))
+		return
+	}
+
+	var payload githubPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	branch, ok := pushedBranch(payload.Ref)
+	if !ok {
+		r.String(http.StatusOK, fmt.Sprintf("ignoring push to %s", payload.Ref))
+		return
+	}
+
+	event := pushEvent{
+		RepoURLs: []string{payload.Repository.CloneURL, payload.Repository.SSHURL, payload.Repository.HTMLURL},
+		Branch:   branch,
+	}
+	// The commits of a forced push do not list what changed relative to the previous head
+	if !payload.Forced {
+		event.ChangedFiles = changedFiles(payload.Commits)
+	}
+
+	c.handlePushEvent(r, event)
+}
+
+// HandleGitLabWebhook handles GitLab push events authenticated with X-Gitlab-Token.
+func (c *Controller) HandleGitLabWebhook(r *gin.Context) {
+	body, ok := c.readWebhookBody(r, "gitlab")
+	if !ok {
+		return
+	}
+
+	token := r.GetHeader("X-Gitlab-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(c.webhookSecrets["gitlab"])) != 1 {
+		r.String(http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	if event := r.GetHeader("X-Gitlab-Event"); event != "Push Hook" {
+		r.String(http.StatusOK, fmt.Sprintf("ignoring %s event", event))
+		return
+	}
+
+	var payload gitlabPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	branch, ok := pushedBranch(payload.Ref)
+	if !ok {
+		r.String(http.StatusOK, fmt.Sprintf("ignoring push to %s", payload.Ref))
+		return
+	}
+
+	event := pushEvent{
+		RepoURLs: []string{payload.Project.GitHTTPURL, payload.Project.GitSSHURL, payload.Project.WebURL},
+		Branch:   branch,
+	}
+	// GitLab truncates the commit list, so path filters can only be applied when it is complete
+	if payload.TotalCommitsCount <= len(payload.Commits) {
+		event.ChangedFiles = changedFiles(payload.Commits)
+	}
+
+	c.handlePushEvent(r, event)
+}
+
+// HandleBitbucketWebhook handles Bitbucket Cloud and Data Center push events signed with X-Hub-Signature.
+// Bitbucket does not include changed files in push payloads, so path filters are not applied.
+func (c *Controller) HandleBitbucketWebhook(r *gin.Context) {
+	body, ok := c.readWebhookBody(r, "bitbucket")
+	if !ok {
+		return
+	}
+
+	if !validHMACSignature(c.webhookSecrets["bitbucket"], body, r.GetHeader("X-Hub-Signature")) {
+		r.String(http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	if event := r.GetHeader("X-Event-Key"); event != "repo:push" && event != "repo:refs_changed" {
+		r.String(http.StatusOK, fmt.Sprintf("ignoring %s event", event))
+		return
+	}
+
+	var payload bitbucketPushPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	repoURLs := []string{payload.Repository.Links.HTML.Href}
+	for _, link := range payload.Repository.Links.Clone {
+		repoURLs = append(repoURLs, link.Href)
+	}
+	if payload.Repository.FullName != "" {
+		repoURLs = append(repoURLs, "https://bitbucket.org/"+payload.Repository.FullName)
+	}
+
+	var branches []string
+	for _, change := range payload.Push.Changes {
+		if change.New.Type == "branch" {
+			branches = append(branches, change.New.Name)
+		}
+	}
+	for _, change := range payload.Changes {
+		if change.Ref.Type == "BRANCH" {
+			branches = append(branches, change.Ref.DisplayID)
+		}
+	}
+
+	var enqueued []string
+	for _, branch := range branches {
+		keys, err := c.enqueueForPush(pushEvent{RepoURLs: repoURLs, Branch: branch})
+		if err != nil {
+			r.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		enqueued = append(enqueued, keys...)
+	}
+
+	r.JSON(http.StatusAccepted, gin.H{"enqueued": enqueued})
+}
+
+// readWebhookBody reads the request body, rejecting the request if the provider has no secret configured.
+func (c *Controller) readWebhookBody(r *gin.Context, provider string) ([]byte, bool) {
+	if c.webhookSecrets[provider] == "" {
+		r.String(http.StatusForbidden, fmt.Sprintf("%s webhook secret is not configured", provider))
+		return nil, false
+	}
+
+	body, err := io.ReadAll(r.Request.Body)
+	if err != nil {
+		r.String(http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	defer func() {
+		if err := r.Request.Body.Close(); err != nil {
+			log.Printf("Error closing request body: %v", err)
+		}
+	}()
+
+	return body, true
+}
+
+func (c *Controller) handlePushEvent(r *gin.Context, event pushEvent) {
+	enqueued, err := c.enqueueForPush(event)
+	if err != nil {
+		r.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.JSON(http.StatusAccepted, gin.H{"enqueued": enqueued})
+}
+
+// enqueueForPush enqueues every Terraform resource tracking the pushed repository and branch
+// whose path filters match the changed files, and returns their keys.
+func (c *Controller) enqueueForPush(event pushEvent) ([]string, error) {
+	resourceList, err := c.dynClient.Resource(terraformGVR).Namespace("").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching Terraform resources: %v", err)
+	}
+
+	pushedRepos := make(map[string]bool)
+	for _, url := range event.RepoURLs {
+		if url != "" {
+			pushedRepos[normalizeRepoURL(url)] = true
+			c.forgetRemoteBranches(normalizeRepoURL(url))
+		}
+	}
+
+	enqueued := []string{}
+	for i := range resourceList.Items {
+		item := &resourceList.Items[i]
+		observed, err := syncRequestFromUnstructured(item)
+		if err != nil {
+			log.Printf("Skipping %s/%s: %v", item.GetNamespace(), item.GetName(), err)
+			continue
+		}
+
+		gitRepo := observed.Parent.Spec.GitRepo
+		if !pushedRepos[normalizeRepoURL(gitRepo.URL)] {
+			continue
+		}
+		if gitRepo.Branch != "" && gitRepo.Branch != event.Branch {
+			continue
+		}
+		if !matchesPaths(gitRepo.Paths, event.ChangedFiles) {
+			log.Printf("Push to %s does not touch paths of %s/%s", event.Branch, item.GetNamespace(), item.GetName())
+			continue
+		}
+
+		c.enqueue(item.GetNamespace(), item.GetName())
+		enqueued = append(enqueued, fmt.Sprintf("%s/%s", item.GetNamespace(), item.GetName()))
+	}
+
+	log.Printf("Push to branch %s enqueued %d Terraform resources", event.Branch, len(enqueued))
+	return enqueued, nil
+}
+
+// validHMACSignature checks a "sha256=<hex>" signature header against the HMAC-SHA256 of body.
+func validHMACSignature(secret string, body []byte, header string) bool {
+	signature, found := strings.CutPrefix(header, "sha256=")
+	if !found {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// pushedBranch returns the branch of a pushed ref. Tags and other refs are not branches, so they
+// never match a resource, not even one tracking any branch.
+func pushedBranch(ref string) (string, bool) {
+	branch, found := strings.CutPrefix(ref, "refs/heads/")
+	return branch, found && branch != ""
+}
+
+// normalizeRepoURL reduces https, ssh and scp-like git URLs to "host/path" so they can be compared.
+func normalizeRepoURL(raw string) string {
+	u := strings.ToLower(strings.TrimSpace(raw))
+	scpLike := !strings.Contains(u, "://") && strings.Contains(u, ":")
+	if _, rest, found := strings.Cut(u, "://"); found {
+		u = rest
+	}
+
+	separator := "/"
+	if scpLike {
+		separator = ":"
+	}
+	host, repoPath, _ := strings.Cut(u, separator)
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	host, _, _ = strings.Cut(host, ":")
+
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	return host + "/" + repoPath
+}
+
+// changedFiles returns the files changed by the commits of a push, nil (unknown) when the push
+// lists no commits, e.g. for a new branch or a rollback to an earlier commit.
+func changedFiles(commits []pushCommit) []string {
+	if len(commits) == 0 {
+		return nil
+	}
+	files := []string{}
+	for _, commit := range commits {
+		files = append(files, commit.Added...)
+		files = append(files, commit.Modified...)
+		files = append(files, commit.Removed...)
+	}
+	return files
+}
+
+// matchesPaths reports whether any changed file falls under one of the path filters.
+// Filters are either directory prefixes or glob patterns. No filters, or an unknown
+// set of changed files, always matches.
+func matchesPaths(filters, files []string) bool {
+	if len(filters) == 0 || files == nil {
+		return true
+	}
+
+	for _, file := range files {
+		for _, filter := range filters {
+			filter = strings.Trim(filter, "/")
+			if filter == "" || strings.HasPrefix(file, filter+"/") {
+				return true
+			}
+			if matched, _ := path.Match(filter, file); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
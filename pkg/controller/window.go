@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alustan/terraform-controller/pkg/util"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ChangeWindow allows applies and destroys on the given days between start and end ("HH:MM").
+// An end before the start spans midnight. No days means every day.
+type ChangeWindow struct {
+	Days     []string `json:"days,omitempty"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	TimeZone string   `json:"timeZone,omitempty"`
+}
+
+// Freeze is a cluster-wide period during which no applies or destroys run.
+type Freeze struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// maxWindowLookahead bounds the search for the next eligible time.
+const maxWindowLookahead = 8 * 24 * time.Hour
+
+// checkChangeWindow returns a Deferred status if an apply or destroy may not run now, or nil if it may.
+// The resource is requeued for the next eligible time.
+func (c *Controller) checkChangeWindow(observed SyncRequest, now time.Time) map[string]interface{} {
+	status, next, found := c.changeWindowDeferral(observed, now)
+	if found {
+		c.queue.AddAfter(queueItem{Namespace: observed.Parent.Metadata.Namespace, Name: observed.Parent.Metadata.Name}, next.Sub(now))
+	}
+	return status
+}
+
+// changeWindowDeferral returns a Deferred status if a change to a resource may not run now, with
+// the next eligible time if one was found, or nil if it may.
+func (c *Controller) changeWindowDeferral(observed SyncRequest, now time.Time) (map[string]interface{}, time.Time, bool) {
+	freezes, err := c.getFreezes()
+	if err != nil {
+		return c.errorResponse("reading change freezes", err), time.Time{}, false
+	}
+
+	windows := observed.Parent.Spec.ChangeWindows
+	allowed, reason, err := changeAllowed(windows, freezes, now)
+	if err != nil {
+		return c.errorResponse("evaluating change windows", err), time.Time{}, false
+	}
+	if allowed {
+		return nil, time.Time{}, false
+	}
+
+	status := map[string]interface{}{
+		"state":   "Deferred",
+		"message": fmt.Sprintf("Run deferred: %s", reason),
+	}
+
+	next, found := nextEligibleTime(windows, freezes, now)
+	if found {
+		status["nextEligibleTime"] = next.UTC().Format(time.RFC3339)
+	}
+	return status, next, found
+}
+
+// getFreezes reads the cluster-wide change freezes. A missing ConfigMap means no freezes.
+func (c *Controller) getFreezes() ([]Freeze, error) {
+	namespace, name := util.GetFreezeConfigMap()
+	configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get freeze ConfigMap: %v", err)
+	}
+
+	var freezes []Freeze
+	if err := yaml.Unmarshal([]byte(configMap.Data["freezes"]), &freezes); err != nil {
+		return nil, fmt.Errorf("invalid freezes in ConfigMap %s/%s: %v", namespace, name, err)
+	}
+	return freezes, nil
+}
+
+// changeAllowed reports whether an apply or destroy may run at t, and why not.
+func changeAllowed(windows []ChangeWindow, freezes []Freeze, t time.Time) (bool, string, error) {
+	for _, freeze := range freezes {
+		if !t.Before(freeze.Start) && t.Before(freeze.End) {
+			reason := fmt.Sprintf("change freeze until %s", freeze.End.UTC().Format(time.RFC3339))
+			if freeze.Reason != "" {
+				reason = fmt.Sprintf("%s (%s)", reason, freeze.Reason)
+			}
+			return false, reason, nil
+		}
+	}
+
+	if len(windows) == 0 {
+		return true, "", nil
+	}
+	for _, window := range windows {
+		open, err := window.contains(t)
+		if err != nil {
+			return false, "", err
+		}
+		if open {
+			return true, "", nil
+		}
+	}
+	return false, "outside of change windows", nil
+}
+
+// nextEligibleTime returns the earliest time from now at which a change is allowed.
+// Candidates are now, the end of every freeze and the start of every window occurrence.
+func nextEligibleTime(windows []ChangeWindow, freezes []Freeze, now time.Time) (time.Time, bool) {
+	candidates := []time.Time{now}
+	for _, freeze := range freezes {
+		if freeze.End.After(now) {
+			candidates = append(candidates, freeze.End)
+		}
+	}
+	for _, window := range windows {
+		candidates = append(candidates, window.startsBetween(now, now.Add(maxWindowLookahead))...)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, candidate := range candidates {
+		if allowed, _, err := changeAllowed(windows, freezes, candidate); err == nil && allowed {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// contains reports whether t falls into an occurrence of the window starting on t's day or the day before.
+func (w ChangeWindow) contains(t time.Time) (bool, error) {
+	location, start, end, err := w.parse()
+	if err != nil {
+		return false, err
+	}
+
+	local := t.In(location)
+	for _, offset := range []int{0, -1} {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, location)
+		if !w.onDay(day.Weekday()) {
+			continue
+		}
+		opens := clockOn(day, start)
+		closes := clockOn(day, end)
+		if end <= start {
+			closes = closes.Add(24 * time.Hour)
+		}
+		if !local.Before(opens) && local.Before(closes) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// startsBetween returns the opening times of the window between from and to.
+func (w ChangeWindow) startsBetween(from, to time.Time) []time.Time {
+	location, start, _, err := w.parse()
+	if err != nil {
+		return nil
+	}
+
+	var starts []time.Time
+	local := from.In(location)
+	for day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location); day.Before(to); day = day.AddDate(0, 0, 1) {
+		opens := clockOn(day, start)
+		if w.onDay(day.Weekday()) && opens.After(from) && opens.Before(to) {
+			starts = append(starts, opens)
+		}
+	}
+	return starts
+}
+
+func (w ChangeWindow) onDay(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if len(day) >= 3 && strings.HasPrefix(strings.ToLower(weekday.String()), strings.ToLower(day)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w ChangeWindow) parse() (*time.Location, time.Duration, time.Duration, error) {
+	location := time.UTC
+	if w.TimeZone != "" {
+		var err error
+		location, err = time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("invalid time zone %q: %v", w.TimeZone, err)
+		}
+	}
+
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return location, start, end, nil
+}
+
+// clockOn returns the wall clock time offset from midnight on day, keeping it stable across DST changes.
+func clockOn(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(offset.Hours()), int(offset.Minutes())%60, 0, 0, day.Location())
+}
+
+// parseClock parses "HH:MM" into the offset from midnight.
+func parseClock(value string) (time.Duration, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// validateWorkingDir rejects working directories outside of the repository. The directory is
+// relative to the repository root, which is the working directory of the image.
+func validateWorkingDir(workingDir string) error {
+	if workingDir == "" {
+		return nil
+	}
+	cleaned := path.Clean(workingDir)
+	if path.IsAbs(workingDir) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("workingDir %q must be a path relative to the repository root", workingDir)
+	}
+	return nil
+}
+
+// workingDirEnvVars returns the WORKING_DIR variable the run pod commands cd into, if set.
+func workingDirEnvVars(spec TerraformConfigSpec) map[string]string {
+	if spec.WorkingDir == "" {
+		return nil
+	}
+	return map[string]string{"WORKING_DIR": spec.WorkingDir}
+}
@@ -0,0 +1,194 @@
+// Package cost estimates the monthly cost change of Terraform plans from a local pricing catalog.
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// defaultHoursPerMonth is the number of hours hourly prices are multiplied by.
+const defaultHoursPerMonth = 730
+
+// Catalog prices Terraform resource types. It is maintained by the teams using it, no pricing API is queried.
+//
+//	currency: USD
+//	resources:
+//	  aws_instance:
+//	    attribute: instance_type
+//	    hourly:
+//	      t3.micro: 0.0104
+//	  aws_ebs_volume:
+//	    storage:
+//	      sizeAttribute: size
+//	      perGBMonth: 0.08
+//	  aws_nat_gateway:
+//	    monthly: 32.85
+type Catalog struct {
+	Currency      string                     `json:"currency,omitempty"`
+	HoursPerMonth float64                    `json:"hoursPerMonth,omitempty"`
+	Resources     map[string]ResourcePricing `json:"resources"`
+}
+
+// ResourcePricing prices a resource type. Its monthly cost is the sum of the flat monthly price,
+// the hourly price selected by the value of attribute, and the price of its storage.
+type ResourcePricing struct {
+	Monthly   float64            `json:"monthly,omitempty"`
+	Attribute string             `json:"attribute,omitempty"`
+	Hourly    map[string]float64 `json:"hourly,omitempty"`
+	Storage   *StoragePricing    `json:"storage,omitempty"`
+}
+
+// StoragePricing prices the size in GB held in sizeAttribute, by the value of typeAttribute if set.
+type StoragePricing struct {
+	SizeAttribute string             `json:"sizeAttribute"`
+	PerGBMonth    float64            `json:"perGBMonth,omitempty"`
+	TypeAttribute string             `json:"typeAttribute,omitempty"`
+	PerGBMonthBy  map[string]float64 `json:"perGBMonthByType,omitempty"`
+}
+
+// Estimate is the monthly cost change of a plan.
+type Estimate struct {
+	Currency     string
+	MonthlyDelta float64
+	Resources    []ResourceEstimate
+	// Unpriced lists the changed resources whose type or attribute value is not in the catalog
+	Unpriced []string
+}
+
+// ResourceEstimate is the monthly cost of a changed resource before and after the plan.
+type ResourceEstimate struct {
+	Address string
+	Type    string
+	Before  float64
+	After   float64
+}
+
+type planJSON struct {
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Type    string `json:"type"`
+		Mode    string `json:"mode"`
+		Change  struct {
+			Actions []string               `json:"actions"`
+			Before  map[string]interface{} `json:"before"`
+			After   map[string]interface{} `json:"after"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// EstimatePlan estimates the monthly cost change of the output of `terraform show -json` for a saved plan.
+func EstimatePlan(catalog Catalog, plan map[string]interface{}) (Estimate, error) {
+	estimate := Estimate{Currency: catalog.Currency}
+	if estimate.Currency == "" {
+		estimate.Currency = "USD"
+	}
+	hours := catalog.HoursPerMonth
+	if hours == 0 {
+		hours = defaultHoursPerMonth
+	}
+
+	raw, err := json.Marshal(plan)
+	if err != nil {
+		return estimate, err
+	}
+	var parsed planJSON
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return estimate, fmt.Errorf("invalid plan: %v", err)
+	}
+
+	for _, change := range parsed.ResourceChanges {
+		if change.Mode == "data" || !changes(change.Change.Actions) {
+			continue
+		}
+		pricing, found := catalog.Resources[change.Type]
+		if !found {
+			estimate.Unpriced = append(estimate.Unpriced, change.Address)
+			continue
+		}
+
+		before, beforePriced := pricing.monthly(change.Change.Before, hours)
+		after, afterPriced := pricing.monthly(change.Change.After, hours)
+		if !beforePriced || !afterPriced {
+			estimate.Unpriced = append(estimate.Unpriced, change.Address)
+		}
+		if before == after {
+			continue
+		}
+		estimate.Resources = append(estimate.Resources, ResourceEstimate{
+			Address: change.Address,
+			Type:    change.Type,
+			Before:  round(before),
+			After:   round(after),
+		})
+		estimate.MonthlyDelta += after - before
+	}
+
+	estimate.MonthlyDelta = round(estimate.MonthlyDelta)
+	sort.Slice(estimate.Resources, func(i, j int) bool {
+		return math.Abs(estimate.Resources[i].After-estimate.Resources[i].Before) > math.Abs(estimate.Resources[j].After-estimate.Resources[j].Before)
+	})
+	return estimate, nil
+}
+
+// changes reports whether the actions of a resource change can change its cost.
+func changes(actions []string) bool {
+	for _, action := range actions {
+		if action == "create" || action == "update" || action == "delete" {
+			return true
+		}
+	}
+	return false
+}
+
+// monthly returns the monthly cost of a resource with the given attributes, zero for a resource
+// that does not exist, and whether every part of its price was found in the catalog.
+func (p ResourcePricing) monthly(attributes map[string]interface{}, hours float64) (float64, bool) {
+	if attributes == nil {
+		return 0, true
+	}
+
+	total := p.Monthly
+	priced := true
+	if p.Attribute != "" {
+		value := fmt.Sprint(attributes[p.Attribute])
+		if price, found := p.Hourly[value]; found {
+			total += price * hours
+		} else {
+			priced = false
+		}
+	}
+
+	if p.Storage != nil {
+		size, ok := number(attributes[p.Storage.SizeAttribute])
+		perGB := p.Storage.PerGBMonth
+		if p.Storage.TypeAttribute != "" {
+			if price, found := p.Storage.PerGBMonthBy[fmt.Sprint(attributes[p.Storage.TypeAttribute])]; found {
+				perGB = price
+			}
+		}
+		if ok {
+			total += size * perGB
+		} else {
+			priced = false
+		}
+	}
+	return total, priced
+}
+
+func number(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case string:
+		parsed, err := strconv.ParseFloat(value, 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
@@ -0,0 +1,136 @@
+package cost
+
+import (
+	"reflect"
+	"testing"
+)
+
+var catalog = Catalog{
+	Resources: map[string]ResourcePricing{
+		"aws_instance": {
+			Attribute: "instance_type",
+			Hourly:    map[string]float64{"t3.micro": 0.0104, "t3.large": 0.0832},
+		},
+		"aws_ebs_volume": {
+			Storage: &StoragePricing{
+				SizeAttribute: "size",
+				PerGBMonth:    0.08,
+				TypeAttribute: "type",
+				PerGBMonthBy:  map[string]float64{"io2": 0.125},
+			},
+		},
+		"aws_nat_gateway": {Monthly: 32.85},
+	},
+}
+
+// change returns a resource change of a JSON plan.
+func change(address, resourceType string, actions []string, before, after map[string]interface{}) map[string]interface{} {
+	values := make([]interface{}, len(actions))
+	for i, action := range actions {
+		values[i] = action
+	}
+	return map[string]interface{}{
+		"address": address,
+		"type":    resourceType,
+		"mode":    "managed",
+		"change":  map[string]interface{}{"actions": values, "before": before, "after": after},
+	}
+}
+
+func TestEstimatePlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog Catalog
+		changes []interface{}
+		want    Estimate
+	}{
+		{
+			name: "created, resized and deleted resources",
+			changes: []interface{}{
+				change("aws_nat_gateway.main", "aws_nat_gateway", []string{"create"}, nil, map[string]interface{}{}),
+				change("aws_instance.web", "aws_instance", []string{"update"}, map[string]interface{}{"instance_type": "t3.micro"}, map[string]interface{}{"instance_type": "t3.large"}),
+				change("aws_ebs_volume.data", "aws_ebs_volume", []string{"delete"}, map[string]interface{}{"size": float64(100)}, nil),
+			},
+			want: Estimate{
+				Currency:     "USD",
+				MonthlyDelta: 77.99,
+				Resources: []ResourceEstimate{
+					{Address: "aws_instance.web", Type: "aws_instance", Before: 7.59, After: 60.74},
+					{Address: "aws_nat_gateway.main", Type: "aws_nat_gateway", Before: 0, After: 32.85},
+					{Address: "aws_ebs_volume.data", Type: "aws_ebs_volume", Before: 8, After: 0},
+				},
+			},
+		},
+		{
+			name: "storage priced by type with a string size",
+			changes: []interface{}{
+				change("aws_ebs_volume.db", "aws_ebs_volume", []string{"create"}, nil, map[string]interface{}{"size": "200", "type": "io2"}),
+			},
+			want: Estimate{
+				Currency:     "USD",
+				MonthlyDelta: 25,
+				Resources:    []ResourceEstimate{{Address: "aws_ebs_volume.db", Type: "aws_ebs_volume", Before: 0, After: 25}},
+			},
+		},
+		{
+			name:    "catalog currency and hours per month",
+			catalog: Catalog{Currency: "EUR", HoursPerMonth: 100, Resources: catalog.Resources},
+			changes: []interface{}{
+				change("aws_instance.web", "aws_instance", []string{"create"}, nil, map[string]interface{}{"instance_type": "t3.micro"}),
+			},
+			want: Estimate{
+				Currency:     "EUR",
+				MonthlyDelta: 1.04,
+				Resources:    []ResourceEstimate{{Address: "aws_instance.web", Type: "aws_instance", Before: 0, After: 1.04}},
+			},
+		},
+		{
+			name: "unpriced types and attribute values",
+			changes: []interface{}{
+				change("aws_lambda_function.api", "aws_lambda_function", []string{"create"}, nil, map[string]interface{}{}),
+				change("aws_instance.gpu", "aws_instance", []string{"create"}, nil, map[string]interface{}{"instance_type": "p4d.24xlarge"}),
+				change("aws_ebs_volume.new", "aws_ebs_volume", []string{"create"}, nil, map[string]interface{}{"size": nil}),
+			},
+			want: Estimate{
+				Currency: "USD",
+				Unpriced: []string{"aws_lambda_function.api", "aws_instance.gpu", "aws_ebs_volume.new"},
+			},
+		},
+		{
+			name: "unchanged resources and data sources",
+			changes: []interface{}{
+				change("aws_instance.web", "aws_instance", []string{"no-op"}, map[string]interface{}{"instance_type": "t3.micro"}, map[string]interface{}{"instance_type": "t3.micro"}),
+				change("aws_instance.tagged", "aws_instance", []string{"update"}, map[string]interface{}{"instance_type": "t3.micro"}, map[string]interface{}{"instance_type": "t3.micro"}),
+				change("aws_instance.read", "aws_instance", []string{"read"}, nil, map[string]interface{}{"instance_type": "t3.large"}),
+				func() map[string]interface{} {
+					data := change("data.aws_instance.existing", "aws_instance", []string{"create"}, nil, map[string]interface{}{"instance_type": "t3.large"})
+					data["mode"] = "data"
+					return data
+				}(),
+			},
+			want: Estimate{Currency: "USD"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.catalog
+			if c.Resources == nil {
+				c = catalog
+			}
+			got, err := EstimatePlan(c, map[string]interface{}{"resource_changes": tt.changes})
+			if err != nil {
+				t.Fatalf("EstimatePlan() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EstimatePlan() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatePlanInvalid(t *testing.T) {
+	if _, err := EstimatePlan(catalog, map[string]interface{}{"resource_changes": "not a list"}); err == nil {
+		t.Errorf("EstimatePlan() error = nil, want an invalid plan error")
+	}
+}
@@ -0,0 +1,57 @@
+package kubernetes
+
+import (
+	"time"
+)
+
+// NewCondition returns a status condition following the Kubernetes conventions.
+func NewCondition(conditionType, status, reason, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":               conditionType,
+		"status":             status,
+		"reason":             reason,
+		"message":            message,
+		"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// mergeConditions returns the existing conditions with those in updated added or replaced by type.
+func mergeConditions(existing, updated interface{}) []interface{} {
+	existingConditions, _ := existing.([]interface{})
+	updatedConditions, _ := updated.([]interface{})
+
+	merged := make([]interface{}, 0, len(existingConditions)+len(updatedConditions))
+	for _, condition := range existingConditions {
+		if !hasConditionType(updatedConditions, conditionTypeOf(condition)) {
+			merged = append(merged, condition)
+		}
+	}
+	return append(merged, updatedConditions...)
+}
+
+// HasCondition reports whether the status carries a condition of the given type and status.
+func HasCondition(status map[string]interface{}, conditionType, conditionStatus string) bool {
+	conditions, _ := status["conditions"].([]interface{})
+	for _, condition := range conditions {
+		fields, _ := condition.(map[string]interface{})
+		if fields["type"] == conditionType && fields["status"] == conditionStatus {
+			return true
+		}
+	}
+	return false
+}
+
+func hasConditionType(conditions []interface{}, conditionType string) bool {
+	for _, condition := range conditions {
+		if conditionTypeOf(condition) == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+func conditionTypeOf(condition interface{}) string {
+	fields, _ := condition.(map[string]interface{})
+	conditionType, _ := fields["type"].(string)
+	return conditionType
+}
@@ -13,7 +13,35 @@ import (
 	"k8s.io/client-go/dynamic"
 )
 
-// UpdateStatus updates the status subresource of a Custom Resource
+// retainedStatusFields are carried over from the current status when an update does not set them,
+// since every phase of a run replaces the whole status.
+var retainedStatusFields = []string{
+	"observedGeneration",
+	"lastAttemptedCommit",
+	"lastAppliedCommit",
+	"lastRunTime",
+	"lastDriftCheckTime",
+	"nextApplyTime",
+	"nextDriftCheckTime",
+	"driftDetected",
+	"driftPlan",
+	"lastHandledReconcileAt",
+	"lastHandledCancelRun",
+	"destroyAttempts",
+	"nextDestroyRetryTime",
+	"output",
+	"stateKey",
+	"workspace",
+	"policy",
+	"plan",
+	"cost",
+	"terraform",
+	"hooks",
+	"imports",
+}
+
+// UpdateStatus updates the status subresource of a Custom Resource.
+// Retained bookkeeping fields and conditions are copied into status so callers returning it keep them too.
 func UpdateStatus(dynClient dynamic.Interface, namespace, name string, status map[string]interface{}) error {
 	resource := schema.GroupVersionResource{
 		Group:    "alustan.io",
@@ -34,6 +62,10 @@ func UpdateStatus(dynClient dynamic.Interface, namespace, name string, status ma
 		return errors.New("status subresource not defined")
 	}
 
+	if existing, ok := unstructuredResource.Object["status"].(map[string]interface{}); ok {
+		retainStatusFields(existing, status)
+	}
+
 	// Update the status
 	unstructuredResource.Object["status"] = status
 
@@ -48,3 +80,57 @@ func UpdateStatus(dynClient dynamic.Interface, namespace, name string, status ma
 	return nil
 }
 
+// MergeStatus sets the given fields on the current status of a Custom Resource, leaving all other fields untouched.
+func MergeStatus(dynClient dynamic.Interface, namespace, name string, fields map[string]interface{}) error {
+	resource := schema.GroupVersionResource{
+		Group:    "alustan.io",
+		Version:  "v1alpha1",
+		Resource: "terraforms",
+	}
+	return MergeResourceStatus(dynClient, resource, namespace, name, fields)
+}
+
+// MergeResourceStatus is MergeStatus for a resource of the given type.
+func MergeResourceStatus(dynClient dynamic.Interface, resource schema.GroupVersionResource, namespace, name string, fields map[string]interface{}) error {
+	unstructuredResource, err := dynClient.Resource(resource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Failed to get resource %s in namespace %s: %v", name, namespace, err)
+		return err
+	}
+
+	status, _ := unstructuredResource.Object["status"].(map[string]interface{})
+	if status == nil {
+		status = make(map[string]interface{})
+	}
+	for field, value := range fields {
+		if field == "conditions" {
+			value = mergeConditions(status["conditions"], value)
+		}
+		status[field] = value
+	}
+	unstructuredResource.Object["status"] = status
+
+	_, err = dynClient.Resource(resource).Namespace(namespace).UpdateStatus(context.Background(), unstructuredResource, metav1.UpdateOptions{})
+	if err != nil {
+		log.Printf("Failed to merge status for resource %s in namespace %s: %v", name, namespace, err)
+		return err
+	}
+	return nil
+}
+
+// retainStatusFields copies the retained fields missing from status out of the existing status,
+// and merges the conditions of status over the existing ones.
+func retainStatusFields(existing, status map[string]interface{}) {
+	for _, field := range retainedStatusFields {
+		if _, set := status[field]; set {
+			continue
+		}
+		if value, found := existing[field]; found {
+			status[field] = value
+		}
+	}
+
+	if conditions, found := existing["conditions"]; found {
+		status["conditions"] = mergeConditions(conditions, status["conditions"])
+	}
+}
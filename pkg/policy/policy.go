@@ -0,0 +1,187 @@
+// Package policy evaluates Rego policies against Terraform plans.
+//
+// A policy module defines deny and warn rules, as conftest does, whose values are sets of
+// messages or of objects with a msg field. The JSON plan is the input of every rule:
+//
+//	package terraform.s3
+//
+//	deny[msg] {
+//		resource := input.resource_changes[_]
+//		resource.type == "aws_s3_bucket_public_access_block"
+//		resource.change.after.block_public_acls == false
+//		msg := sprintf("%s must block public ACLs", [resource.address])
+//	}
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// Severities of the rules evaluated in every module, and of the errors of broken modules.
+const (
+	SeverityDeny  = "deny"
+	SeverityWarn  = "warn"
+	SeverityError = "error"
+)
+
+// Module is the Rego source of a policy, named after where it was loaded from.
+type Module struct {
+	Name   string
+	Source string
+}
+
+// Result is a message of a deny or warn rule.
+type Result struct {
+	Policy   string `json:"policy"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Evaluate compiles the modules together and evaluates their deny and warn rules against input.
+// Modules that fail to parse, compile or evaluate are reported in errs, one result per module,
+// and left out, so a broken module does not keep the others from being evaluated.
+func Evaluate(ctx context.Context, modules []Module, input interface{}) (violations, warnings, errs []Result) {
+	parsed := make(map[string]*ast.Module, len(modules))
+	for _, module := range modules {
+		m, err := ast.ParseModule(module.Name, module.Source)
+		if err != nil {
+			errs = append(errs, Result{Policy: module.Name, Severity: SeverityError, Message: fmt.Sprintf("invalid policy: %v", err)})
+			continue
+		}
+		if m == nil {
+			errs = append(errs, Result{Policy: module.Name, Severity: SeverityError, Message: "invalid policy: empty module"})
+			continue
+		}
+		parsed[module.Name] = m
+	}
+
+	compiler, failed := compile(parsed)
+	errs = append(errs, failed...)
+
+	names := make([]string, 0, len(parsed))
+	for name := range parsed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		module := parsed[name]
+		for _, severity := range []string{SeverityDeny, SeverityWarn} {
+			if !definesRule(module, severity) {
+				continue
+			}
+			messages, err := evaluateRule(ctx, compiler, module.Package.Path.String()+"."+severity, input)
+			if err != nil {
+				errs = append(errs, Result{Policy: name, Severity: SeverityError, Message: fmt.Sprintf("failed to evaluate %s: %v", severity, err)})
+				continue
+			}
+			for _, message := range messages {
+				result := Result{Policy: name, Severity: severity, Message: message}
+				if severity == SeverityDeny {
+					violations = append(violations, result)
+				} else {
+					warnings = append(warnings, result)
+				}
+			}
+		}
+	}
+	return violations, warnings, errs
+}
+
+// compile compiles the modules, removing those the compiler reports errors in until the others
+// compile. It returns the errors of the removed modules.
+func compile(modules map[string]*ast.Module) (*ast.Compiler, []Result) {
+	var errs []Result
+	for {
+		compiler := ast.NewCompiler()
+		if compiler.Compile(modules); !compiler.Failed() {
+			return compiler, errs
+		}
+
+		messages := make(map[string][]string)
+		for _, err := range compiler.Errors {
+			if err.Location != nil && modules[err.Location.File] != nil {
+				messages[err.Location.File] = append(messages[err.Location.File], err.Error())
+			}
+		}
+		// Errors not located in a module fail all of them
+		if len(messages) == 0 {
+			for name := range modules {
+				messages[name] = []string{compiler.Errors.Error()}
+			}
+		}
+
+		names := make([]string, 0, len(messages))
+		for name := range messages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			errs = append(errs, Result{Policy: name, Severity: SeverityError, Message: fmt.Sprintf("failed to compile policy: %s", strings.Join(messages[name], "; "))})
+			delete(modules, name)
+		}
+	}
+}
+
+func definesRule(module *ast.Module, name string) bool {
+	for _, rule := range module.Rules {
+		if rule.Head.Name.String() == name || rule.Head.Ref().String() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateRule returns the messages of a rule. A rule that is simply true reports its own name.
+func evaluateRule(ctx context.Context, compiler *ast.Compiler, query string, input interface{}) ([]string, error) {
+	results, err := rego.New(rego.Compiler(compiler), rego.Query(query), rego.Input(input)).Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, nil
+	}
+
+	var messages []string
+	switch value := results[0].Expressions[0].Value.(type) {
+	case bool:
+		if value {
+			messages = append(messages, query)
+		}
+	case []interface{}:
+		for _, item := range value {
+			messages = append(messages, message(item))
+		}
+	case map[string]interface{}:
+		for key, item := range value {
+			if item == true {
+				messages = append(messages, key)
+			} else {
+				messages = append(messages, message(item))
+			}
+		}
+	default:
+		messages = append(messages, message(value))
+	}
+	sort.Strings(messages)
+	return messages, nil
+}
+
+// message returns the msg field of an object result, or the result itself.
+func message(value interface{}) string {
+	if object, ok := value.(map[string]interface{}); ok {
+		if msg, ok := object["msg"].(string); ok {
+			return msg
+		}
+	}
+	if text, ok := value.(string); ok {
+		return text
+	}
+	return fmt.Sprint(value)
+}
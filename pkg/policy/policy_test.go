@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// plan is a JSON plan reduced to what the test policies read.
+var plan = map[string]interface{}{
+	"resource_changes": []interface{}{
+		map[string]interface{}{
+			"address": "aws_s3_bucket_public_access_block.logs",
+			"type":    "aws_s3_bucket_public_access_block",
+			"change":  map[string]interface{}{"actions": []interface{}{"create"}, "after": map[string]interface{}{"block_public_acls": false}},
+		},
+		map[string]interface{}{
+			"address": "aws_instance.web",
+			"type":    "aws_instance",
+			"change":  map[string]interface{}{"actions": []interface{}{"delete"}, "after": nil},
+		},
+	},
+}
+
+const s3Policy = `package terraform.s3
+
+deny[msg] {
+	resource := input.resource_changes[_]
+	resource.type == "aws_s3_bucket_public_access_block"
+	resource.change.after.block_public_acls == false
+	msg := sprintf("%s must block public ACLs", [resource.address])
+}
+`
+
+const deletePolicy = `package terraform.delete
+
+warn[{"msg": msg}] {
+	resource := input.resource_changes[_]
+	resource.change.actions[_] == "delete"
+	msg := sprintf("%s is deleted", [resource.address])
+}
+
+deny[msg] {
+	resource := input.resource_changes[_]
+	resource.type == "aws_db_instance"
+	resource.change.actions[_] == "delete"
+	msg := sprintf("%s must not be deleted", [resource.address])
+}
+`
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name           string
+		modules        []Module
+		wantViolations []Result
+		wantWarnings   []Result
+		// wantErrs are the policies reported broken with the start of their message
+		wantErrs map[string]string
+	}{
+		{
+			name:    "deny and warn messages",
+			modules: []Module{{Name: "s3.rego", Source: s3Policy}, {Name: "delete.rego", Source: deletePolicy}},
+			wantViolations: []Result{
+				{Policy: "s3.rego", Severity: SeverityDeny, Message: "aws_s3_bucket_public_access_block.logs must block public ACLs"},
+			},
+			wantWarnings: []Result{
+				{Policy: "delete.rego", Severity: SeverityWarn, Message: "aws_instance.web is deleted"},
+			},
+		},
+		{
+			name:    "boolean rule",
+			modules: []Module{{Name: "any.rego", Source: "package terraform.any\n\nwarn {\n\tcount(input.resource_changes) > 1\n}\n"}},
+			wantWarnings: []Result{
+				{Policy: "any.rego", Severity: SeverityWarn, Message: "data.terraform.any.warn"},
+			},
+		},
+		{
+			name:    "module that fails to parse",
+			modules: []Module{{Name: "broken.rego", Source: "package terraform.broken\n\ndeny[msg] {"}, {Name: "s3.rego", Source: s3Policy}},
+			wantViolations: []Result{
+				{Policy: "s3.rego", Severity: SeverityDeny, Message: "aws_s3_bucket_public_access_block.logs must block public ACLs"},
+			},
+			wantErrs: map[string]string{"broken.rego": "invalid policy: "},
+		},
+		{
+			name:    "empty module",
+			modules: []Module{{Name: "empty.rego", Source: ""}, {Name: "s3.rego", Source: s3Policy}},
+			wantViolations: []Result{
+				{Policy: "s3.rego", Severity: SeverityDeny, Message: "aws_s3_bucket_public_access_block.logs must block public ACLs"},
+			},
+			wantErrs: map[string]string{"empty.rego": "invalid policy: "},
+		},
+		{
+			name: "module that fails to compile",
+			modules: []Module{
+				{Name: "undefined.rego", Source: "package terraform.undefined\n\ndeny[msg] {\n\tmsg := undefined_function(input)\n}\n"},
+				{Name: "delete.rego", Source: deletePolicy},
+			},
+			wantWarnings: []Result{
+				{Policy: "delete.rego", Severity: SeverityWarn, Message: "aws_instance.web is deleted"},
+			},
+			wantErrs: map[string]string{"undefined.rego": "failed to compile policy: "},
+		},
+		{
+			name: "module that fails to evaluate",
+			modules: []Module{
+				{Name: "conflict.rego", Source: "package terraform.conflict\n\ndeny = \"a\" {\n\ttrue\n}\n\ndeny = \"b\" {\n\ttrue\n}\n"},
+				{Name: "s3.rego", Source: s3Policy},
+			},
+			wantViolations: []Result{
+				{Policy: "s3.rego", Severity: SeverityDeny, Message: "aws_s3_bucket_public_access_block.logs must block public ACLs"},
+			},
+			wantErrs: map[string]string{"conflict.rego": "failed to evaluate deny: "},
+		},
+		{
+			name:    "no rules",
+			modules: []Module{{Name: "lib.rego", Source: "package terraform.lib\n\nx := 1\n"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, warnings, errs := Evaluate(context.Background(), tt.modules, plan)
+			if !reflect.DeepEqual(violations, tt.wantViolations) {
+				t.Errorf("violations = %v, want %v", violations, tt.wantViolations)
+			}
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("warnings = %v, want %v", warnings, tt.wantWarnings)
+			}
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("errs = %v, want policies %v", errs, tt.wantErrs)
+			}
+			for _, err := range errs {
+				prefix, found := tt.wantErrs[err.Policy]
+				if !found || err.Severity != SeverityError || !strings.HasPrefix(err.Message, prefix) {
+					t.Errorf("err %+v, want policies %v", err, tt.wantErrs)
+				}
+			}
+		})
+	}
+}
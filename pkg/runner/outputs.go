@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// RedactedValue replaces the value of sensitive outputs in results, logs and status.
+	RedactedValue = "(sensitive value)"
+	// SensitiveOutputsMarker is written to stderr once the values of the sensitive outputs were
+	// written to the sensitive outputs file. The run then waits for the controller to read them.
+	SensitiveOutputsMarker = "----- SENSITIVE OUTPUTS WRITTEN -----"
+	// SensitiveOutputsReadSuffix names the file the controller creates next to the sensitive
+	// outputs file once it read them.
+	SensitiveOutputsReadSuffix = ".read"
+	// sensitiveOutputsTimeout is how long a run waits for the controller to read its sensitive outputs
+	sensitiveOutputsTimeout = 2 * time.Minute
+)
+
+// RedactOutputs splits `terraform output -json` outputs into the outputs with the value of
+// sensitive ones replaced by RedactedValue, and the values of the sensitive outputs by name.
+// Outputs redacted before are left as they are.
+func RedactOutputs(outputs map[string]interface{}) (redacted, sensitive map[string]interface{}) {
+	redacted = make(map[string]interface{}, len(outputs))
+	sensitive = map[string]interface{}{}
+	for name, output := range outputs {
+		fields, ok := output.(map[string]interface{})
+		if !ok || fields["sensitive"] != true || fields["value"] == RedactedValue {
+			redacted[name] = output
+			continue
+		}
+		sensitive[name] = fields["value"]
+		copied := make(map[string]interface{}, len(fields))
+		for key, value := range fields {
+			copied[key] = value
+		}
+		copied["value"] = RedactedValue
+		redacted[name] = copied
+	}
+	return redacted, sensitive
+}
+
+// shareSensitiveOutputs writes the values of the sensitive outputs to the sensitive outputs file
+// and waits until the controller read them. The file is removed before the run ends. A
+// controller that does not read them in time only leaves them unread.
+func (r *run) shareSensitiveOutputs(sensitive map[string]interface{}) error {
+	path := r.config.SensitiveOutputsFile
+	content, err := json.Marshal(sensitive)
+	if err != nil {
+		return fmt.Errorf("failed to encode sensitive outputs: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("failed to write sensitive outputs: %v", err)
+	}
+	defer os.Remove(path)
+	defer os.Remove(path + SensitiveOutputsReadSuffix)
+
+	fmt.Fprintln(r.config.Stderr, SensitiveOutputsMarker)
+	deadline := time.Now().Add(r.config.sensitiveOutputsTimeout())
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path + SensitiveOutputsReadSuffix); err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	fmt.Fprintln(r.config.Stderr, "sensitive outputs were not read by the controller")
+	return nil
+}
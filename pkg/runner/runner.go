@@ -0,0 +1,225 @@
+// Package runner runs the Terraform lifecycle of a resource inside its run pod. Each step is
+// reported as a JSON event line on stdout while Terraform writes to stderr, so the last line
+// of the pod logs is always the event the controller reads the result from.
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Operations of the runner.
+const (
+	OperationApply   = "apply"
+	OperationDestroy = "destroy"
+)
+
+// Event types and step statuses.
+const (
+	EventStep   = "step"
+	EventResult = "result"
+
+	StatusStarted   = "started"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// planFile is the saved plan applied by the apply step.
+const planFile = "runner.tfplan"
+
+// Event is a JSON line written to stdout.
+type Event struct {
+	Time      string                 `json:"time"`
+	Type      string                 `json:"type"`
+	Operation string                 `json:"operation,omitempty"`
+	Step      string                 `json:"step,omitempty"`
+	Status    string                 `json:"status,omitempty"`
+	Duration  float64                `json:"durationSeconds,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Outputs   map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// Config configures a run. PlanFile is a saved plan, relative to the working directory,
+// applied instead of planning. The result of an apply carries sensitive outputs redacted;
+// with SensitiveOutputsFile set their values are written to it for the controller, which is
+// waited for up to SensitiveOutputsTimeout, two minutes if zero.
+type Config struct {
+	RepoDir                 string
+	WorkingDir              string
+	Workspace               string
+	PlanFile                string
+	SensitiveOutputsFile    string
+	SensitiveOutputsTimeout time.Duration
+	Stdout                  io.Writer
+	Stderr                  io.Writer
+}
+
+// sensitiveOutputsTimeout returns how long a run waits for its sensitive outputs to be read.
+func (c Config) sensitiveOutputsTimeout() time.Duration {
+	if c.SensitiveOutputsTimeout == 0 {
+		return sensitiveOutputsTimeout
+	}
+	return c.SensitiveOutputsTimeout
+}
+
+// ConfigFromEnv returns the configuration of a run pod: the repository is the current
+// directory and WORKING_DIR and TF_WORKSPACE are set by the controller.
+func ConfigFromEnv() Config {
+	repoDir, err := os.Getwd()
+	if err != nil {
+		repoDir = "."
+	}
+	return Config{
+		RepoDir:    repoDir,
+		WorkingDir: os.Getenv("WORKING_DIR"),
+		Workspace:  os.Getenv("TF_WORKSPACE"),
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+	}
+}
+
+type run struct {
+	config    Config
+	operation string
+	encoder   *json.Encoder
+}
+
+// Run runs an operation, emitting a step event when each step starts and ends and a result
+// event once all steps succeeded. The result of an apply carries the Terraform outputs, with
+// the values of sensitive outputs redacted.
+func Run(config Config, operation string) error {
+	if operation != OperationApply && operation != OperationDestroy {
+		return fmt.Errorf("unsupported operation %q, expected apply or destroy", operation)
+	}
+	if config.PlanFile != "" && operation != OperationApply {
+		return fmt.Errorf("a saved plan can only be applied")
+	}
+	r := &run{config: config, operation: operation, encoder: json.NewEncoder(config.Stdout)}
+
+	if err := r.terraform("init", "init", "-input=false"); err != nil {
+		return err
+	}
+	if config.Workspace != "" {
+		// TF_WORKSPACE overrides the selection, so it is left out while selecting. A workspace
+		// that cannot be selected is created, select -or-create needs Terraform 1.4.
+		err := r.step("workspace", func() error {
+			if err := r.command(withoutEnv("TF_WORKSPACE"), "terraform", "workspace", "select", config.Workspace).Run(); err == nil {
+				return nil
+			}
+			return r.command(withoutEnv("TF_WORKSPACE"), "terraform", "workspace", "new", config.Workspace).Run()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var outputs map[string]interface{}
+	if operation == OperationApply {
+		plan := config.PlanFile
+		if plan == "" {
+			plan = planFile
+			if err := r.terraform("validate", "validate", "-no-color"); err != nil {
+				return err
+			}
+			if err := r.terraform("plan", "plan", "-input=false", "-out="+plan); err != nil {
+				return err
+			}
+		}
+		if err := r.terraform("apply", "apply", "-input=false", plan); err != nil {
+			return err
+		}
+		all, err := r.outputs()
+		if err != nil {
+			return err
+		}
+		var sensitive map[string]interface{}
+		outputs, sensitive = RedactOutputs(all)
+		if len(sensitive) > 0 && config.SensitiveOutputsFile != "" {
+			if err := r.shareSensitiveOutputs(sensitive); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := r.terraform("destroy", "destroy", "-input=false", "-auto-approve"); err != nil {
+			return err
+		}
+	}
+
+	r.emit(Event{Type: EventResult, Outputs: outputs})
+	return nil
+}
+
+func (r *run) terraform(step string, args ...string) error {
+	return r.step(step, r.command(os.Environ(), "terraform", args...).Run)
+}
+
+// outputs exports the Terraform outputs as the step reading them.
+func (r *run) outputs() (map[string]interface{}, error) {
+	cmd := r.command(os.Environ(), "terraform", "output", "-json")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	outputs := map[string]interface{}{}
+	err := r.step("outputs", func() error {
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &outputs); err != nil {
+			return fmt.Errorf("invalid terraform output: %v", err)
+		}
+		return nil
+	})
+	return outputs, err
+}
+
+// command returns a command run in the working directory writing to stderr.
+func (r *run) command(env []string, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = r.config.WorkingDir
+	if cmd.Dir == "" {
+		cmd.Dir = r.config.RepoDir
+	}
+	cmd.Env = env
+	cmd.Stdout = r.config.Stderr
+	cmd.Stderr = r.config.Stderr
+	return cmd
+}
+
+func (r *run) step(step string, fn func() error) error {
+	start := time.Now()
+	r.emit(Event{Type: EventStep, Step: step, Status: StatusStarted})
+
+	err := fn()
+	event := Event{Type: EventStep, Step: step, Status: StatusSucceeded, Duration: time.Since(start).Round(time.Millisecond).Seconds()}
+	if err != nil {
+		err = fmt.Errorf("%s failed: %v", step, err)
+		event.Status = StatusFailed
+		event.Error = err.Error()
+	}
+	r.emit(event)
+	return err
+}
+
+func (r *run) emit(event Event) {
+	event.Time = time.Now().UTC().Format(time.RFC3339)
+	event.Operation = r.operation
+	// Events are best effort, a closed stdout must not fail the run
+	_ = r.encoder.Encode(event)
+}
+
+// withoutEnv returns the environment of the runner without the given variable.
+func withoutEnv(name string) []string {
+	var env []string
+	for _, value := range os.Environ() {
+		if !strings.HasPrefix(value, name+"=") {
+			env = append(env, value)
+		}
+	}
+	return env
+}
@@ -0,0 +1,403 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTerraform records its arguments, and TF_WORKSPACE for workspace commands, to
+// $FAKE_TERRAFORM_LOG and exits with 3 for the subcommand, or subcommand and first argument,
+// named by $FAKE_TERRAFORM_FAIL.
+// `output -json` prints $FAKE_TERRAFORM_OUTPUT.
+const fakeTerraform = `#!/bin/sh
+if [ "$1" = workspace ]; then
+  echo "$* TF_WORKSPACE=$TF_WORKSPACE" >> "$FAKE_TERRAFORM_LOG"
+else
+  echo "$*" >> "$FAKE_TERRAFORM_LOG"
+fi
+echo "terraform $1 output" >&2
+if [ "$1" = "$FAKE_TERRAFORM_FAIL" ] || [ "$1 $2" = "$FAKE_TERRAFORM_FAIL" ]; then
+  exit 3
+fi
+if [ "$1" = output ]; then
+  printf '%s\n' "$FAKE_TERRAFORM_OUTPUT"
+fi
+`
+
+type fakeRun struct {
+	config Config
+	log    string
+}
+
+// newFakeRun puts the fake terraform first on PATH and returns a run configuration in a
+// temporary repository whose working directory is infra.
+func newFakeRun(t *testing.T) *fakeRun {
+	t.Helper()
+	dir := t.TempDir()
+	binDir := filepath.Join(dir, "bin")
+	repoDir := filepath.Join(dir, "repo")
+	for _, d := range []string{binDir, filepath.Join(repoDir, "infra")} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(binDir, "terraform"), []byte(fakeTerraform), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	log := filepath.Join(dir, "terraform.log")
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_TERRAFORM_LOG", log)
+	t.Setenv("FAKE_TERRAFORM_FAIL", "")
+	t.Setenv("FAKE_TERRAFORM_OUTPUT", `{"vpc_id":{"sensitive":false,"type":"string","value":"vpc-1"}}`)
+	t.Setenv("TF_WORKSPACE", "")
+
+	return &fakeRun{
+		config: Config{RepoDir: repoDir, WorkingDir: filepath.Join(repoDir, "infra"), Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}},
+		log:    log,
+	}
+}
+
+// events returns the events written to stdout, failing on lines that are not events.
+func (f *fakeRun) events(t *testing.T) []Event {
+	t.Helper()
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(f.config.Stdout.(*bytes.Buffer).Bytes()))
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// calls returns the terraform invocations, one per line.
+func (f *fakeRun) calls(t *testing.T) []string {
+	t.Helper()
+	data, err := os.ReadFile(f.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// sequence summarizes events as type:step:status, with the error of failed steps.
+func sequence(events []Event) []string {
+	var summary []string
+	for _, event := range events {
+		entry := event.Type
+		if event.Type == EventStep {
+			entry += ":" + event.Step + ":" + event.Status
+		}
+		if event.Error != "" {
+			entry += ":" + event.Error
+		}
+		summary = append(summary, entry)
+	}
+	return summary
+}
+
+func TestRunEvents(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		workspace string
+		planFile  string
+		fail      string
+		wantSteps []string
+		wantCalls []string
+	}{
+		{
+			name:      "apply",
+			operation: OperationApply,
+			wantSteps: []string{
+				"step:init:started", "step:init:succeeded",
+				"step:validate:started", "step:validate:succeeded",
+				"step:plan:started", "step:plan:succeeded",
+				"step:apply:started", "step:apply:succeeded",
+				"step:outputs:started", "step:outputs:succeeded",
+				"result",
+			},
+			wantCalls: []string{"init -input=false", "validate -no-color", "plan -input=false -out=runner.tfplan", "apply -input=false runner.tfplan", "output -json"},
+		},
+		{
+			name:      "apply of a saved plan",
+			operation: OperationApply,
+			planFile:  "controller.tfplan",
+			wantSteps: []string{
+				"step:init:started", "step:init:succeeded",
+				"step:apply:started", "step:apply:succeeded",
+				"step:outputs:started", "step:outputs:succeeded",
+				"result",
+			},
+			wantCalls: []string{"init -input=false", "apply -input=false controller.tfplan", "output -json"},
+		},
+		{
+			name:      "destroy in a workspace",
+			operation: OperationDestroy,
+			workspace: "staging",
+			wantSteps: []string{
+				"step:init:started", "step:init:succeeded",
+				"step:workspace:started", "step:workspace:succeeded",
+				"step:destroy:started", "step:destroy:succeeded",
+				"result",
+			},
+			// TF_WORKSPACE would override the selection, so it is unset while selecting
+			wantCalls: []string{"init -input=false", "workspace select staging TF_WORKSPACE=", "destroy -input=false -auto-approve"},
+		},
+		{
+			name:      "destroy in a missing workspace",
+			operation: OperationDestroy,
+			workspace: "staging",
+			fail:      "workspace select",
+			wantSteps: []string{
+				"step:init:started", "step:init:succeeded",
+				"step:workspace:started", "step:workspace:succeeded",
+				"step:destroy:started", "step:destroy:succeeded",
+				"result",
+			},
+			wantCalls: []string{"init -input=false", "workspace select staging TF_WORKSPACE=", "workspace new staging TF_WORKSPACE=", "destroy -input=false -auto-approve"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRun(t)
+			if tt.workspace != "" {
+				t.Setenv("TF_WORKSPACE", tt.workspace)
+			}
+			t.Setenv("FAKE_TERRAFORM_FAIL", tt.fail)
+			f.config.Workspace = tt.workspace
+			f.config.PlanFile = tt.planFile
+
+			if err := Run(f.config, tt.operation); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			events := f.events(t)
+			if got := sequence(events); !reflect.DeepEqual(got, tt.wantSteps) {
+				t.Errorf("events = %v, want %v", got, tt.wantSteps)
+			}
+			if got := f.calls(t); !reflect.DeepEqual(got, tt.wantCalls) {
+				t.Errorf("terraform calls = %v, want %v", got, tt.wantCalls)
+			}
+			for _, event := range events {
+				if event.Operation != tt.operation || event.Time == "" {
+					t.Errorf("event %+v lacks operation %s or time", event, tt.operation)
+				}
+			}
+			if !strings.Contains(f.config.Stderr.(*bytes.Buffer).String(), "terraform init output") {
+				t.Errorf("terraform output not written to stderr")
+			}
+
+			result := events[len(events)-1]
+			var wantOutputs map[string]interface{}
+			if tt.operation == OperationApply {
+				wantOutputs = map[string]interface{}{"vpc_id": map[string]interface{}{"sensitive": false, "type": "string", "value": "vpc-1"}}
+			}
+			if !reflect.DeepEqual(result.Outputs, wantOutputs) {
+				t.Errorf("result outputs = %v, want %v", result.Outputs, wantOutputs)
+			}
+		})
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		fail      string
+		output    string
+		wantSteps []string
+		wantErr   string
+	}{
+		{
+			name:      "failed plan",
+			operation: OperationApply,
+			fail:      "plan",
+			wantSteps: []string{
+				"step:init:started", "step:init:succeeded",
+				"step:validate:started", "step:validate:succeeded",
+				"step:plan:started", "step:plan:failed:plan failed: exit status 3",
+			},
+			wantErr: "plan failed: exit status 3",
+		},
+		{
+			name:      "failed init",
+			operation: OperationDestroy,
+			fail:      "init",
+			wantSteps: []string{"step:init:started", "step:init:failed:init failed: exit status 3"},
+			wantErr:   "init failed: exit status 3",
+		},
+		{
+			name:      "invalid outputs",
+			operation: OperationApply,
+			output:    "[]",
+			wantSteps: []string{
+				"step:init:started", "step:init:succeeded",
+				"step:validate:started", "step:validate:succeeded",
+				"step:plan:started", "step:plan:succeeded",
+				"step:apply:started", "step:apply:succeeded",
+				"step:outputs:started", "step:outputs:failed:outputs failed: invalid terraform output: json: cannot unmarshal array into Go value of type map[string]interface {}",
+			},
+			wantErr: "outputs failed: invalid terraform output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRun(t)
+			t.Setenv("FAKE_TERRAFORM_FAIL", tt.fail)
+			if tt.output != "" {
+				t.Setenv("FAKE_TERRAFORM_OUTPUT", tt.output)
+			}
+
+			err := Run(f.config, tt.operation)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+			// A failed step ends the run, without a result event
+			if got := sequence(f.events(t)); !reflect.DeepEqual(got, tt.wantSteps) {
+				t.Errorf("events = %v, want %v", got, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func TestRunRejectsInvalidOperations(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		planFile  string
+		wantErr   string
+	}{
+		{name: "unknown operation", operation: "plan", wantErr: `unsupported operation "plan"`},
+		{name: "destroy of a saved plan", operation: OperationDestroy, planFile: "controller.tfplan", wantErr: "a saved plan can only be applied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRun(t)
+			f.config.PlanFile = tt.planFile
+
+			err := Run(f.config, tt.operation)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+			if events := f.events(t); len(events) != 0 {
+				t.Errorf("events = %v, want none", sequence(events))
+			}
+			if calls := f.calls(t); len(calls) != 0 {
+				t.Errorf("terraform calls = %v, want none", calls)
+			}
+		})
+	}
+}
+
+func TestSensitiveOutputs(t *testing.T) {
+	const outputs = `{"password":{"sensitive":true,"type":"string","value":"secret"},"vpc_id":{"sensitive":false,"type":"string","value":"vpc-1"}}`
+	wantOutputs := map[string]interface{}{
+		"password": map[string]interface{}{"sensitive": true, "type": "string", "value": RedactedValue},
+		"vpc_id":   map[string]interface{}{"sensitive": false, "type": "string", "value": "vpc-1"},
+	}
+
+	tests := []struct {
+		name       string
+		share      bool
+		read       bool
+		wantShared map[string]interface{}
+		wantStderr string
+	}{
+		{
+			name: "redacted without a sensitive outputs file",
+		},
+		{
+			name:       "read by the controller",
+			share:      true,
+			read:       true,
+			wantShared: map[string]interface{}{"password": "secret"},
+		},
+		{
+			name:       "not read by the controller",
+			share:      true,
+			wantShared: map[string]interface{}{"password": "secret"},
+			wantStderr: "sensitive outputs were not read by the controller",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeRun(t)
+			t.Setenv("FAKE_TERRAFORM_OUTPUT", outputs)
+			file := filepath.Join(t.TempDir(), "outputs.json")
+			if tt.share {
+				f.config.SensitiveOutputsFile = file
+				f.config.SensitiveOutputsTimeout = 3 * time.Second
+			}
+
+			// Plays the controller, reading the values once the file was written
+			shared := make(chan map[string]interface{}, 1)
+			if tt.share {
+				go func() {
+					deadline := time.Now().Add(f.config.SensitiveOutputsTimeout)
+					for time.Now().Before(deadline) {
+						if data, err := os.ReadFile(file); err == nil {
+							var values map[string]interface{}
+							if err := json.Unmarshal(data, &values); err != nil {
+								t.Errorf("invalid sensitive outputs file: %v", err)
+							}
+							if tt.read {
+								if err := os.WriteFile(file+SensitiveOutputsReadSuffix, nil, 0o600); err != nil {
+									t.Errorf("writing read file: %v", err)
+								}
+							}
+							shared <- values
+							return
+						}
+						time.Sleep(10 * time.Millisecond)
+					}
+					shared <- nil
+				}()
+			}
+
+			if err := Run(f.config, OperationApply); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			events := f.events(t)
+			if got := events[len(events)-1].Outputs; !reflect.DeepEqual(got, wantOutputs) {
+				t.Errorf("result outputs = %v, want %v", got, wantOutputs)
+			}
+			if strings.Contains(f.config.Stdout.(*bytes.Buffer).String(), "secret") {
+				t.Errorf("sensitive value written to stdout")
+			}
+			if tt.share {
+				if got := <-shared; !reflect.DeepEqual(got, tt.wantShared) {
+					t.Errorf("shared outputs = %v, want %v", got, tt.wantShared)
+				}
+				if _, err := os.Stat(file); !os.IsNotExist(err) {
+					t.Errorf("sensitive outputs file left behind")
+				}
+			}
+			stderr := f.config.Stderr.(*bytes.Buffer).String()
+			if tt.share != strings.Contains(stderr, SensitiveOutputsMarker) {
+				t.Errorf("stderr = %q, want marker %v", stderr, tt.share)
+			}
+			if tt.wantStderr != "" && !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+// Package sops decrypts SOPS encrypted YAML and JSON files with age and PGP keys.
+package sops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// Keys holds the private keys used to decrypt the data key of a file.
+type Keys struct {
+	Age []age.Identity
+	PGP openpgp.EntityList
+}
+
+// ParseKeys reads the keys of a key Secret: entries ending in .agekey hold age identities
+// and entries ending in .asc hold armored PGP private keys.
+func ParseKeys(data map[string][]byte) (Keys, error) {
+	var keys Keys
+	for name, value := range data {
+		switch {
+		case strings.HasSuffix(name, ".agekey"):
+			identities, err := age.ParseIdentities(bytes.NewReader(value))
+			if err != nil {
+				return keys, fmt.Errorf("invalid age identities in %s: %v", name, err)
+			}
+			keys.Age = append(keys.Age, identities...)
+		case strings.HasSuffix(name, ".asc"):
+			entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(value))
+			if err != nil {
+				return keys, fmt.Errorf("invalid PGP keys in %s: %v", name, err)
+			}
+			keys.PGP = append(keys.PGP, entities...)
+		}
+	}
+	if len(keys.Age) == 0 && len(keys.PGP) == 0 {
+		return keys, fmt.Errorf("no .agekey or .asc keys found")
+	}
+	return keys, nil
+}
+
+type metadata struct {
+	KeyGroups []interface{} `yaml:"key_groups"`
+	Age       []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	PGP []struct {
+		Fingerprint string `yaml:"fp"`
+		Enc         string `yaml:"enc"`
+	} `yaml:"pgp"`
+	LastModified      string `yaml:"lastmodified"`
+	MAC               string `yaml:"mac"`
+	UnencryptedSuffix string `yaml:"unencrypted_suffix"`
+	EncryptedSuffix   string `yaml:"encrypted_suffix"`
+	UnencryptedRegex  string `yaml:"unencrypted_regex"`
+	EncryptedRegex    string `yaml:"encrypted_regex"`
+}
+
+// Decrypt decrypts a SOPS encrypted YAML or JSON document and verifies its MAC.
+// It returns the top-level keys of the document without the sops metadata.
+func Decrypt(data []byte, keys Keys) (map[string]interface{}, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse document: %v", err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("document is not a map")
+	}
+	root := document.Content[0]
+
+	var meta metadata
+	found := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sops" {
+			if err := root.Content[i+1].Decode(&meta); err != nil {
+				return nil, fmt.Errorf("invalid sops metadata: %v", err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("document is not encrypted with sops")
+	}
+	if len(meta.KeyGroups) > 0 {
+		return nil, fmt.Errorf("key groups are not supported")
+	}
+
+	dataKey, err := meta.dataKey(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decrypter{meta: meta, key: dataKey, hash: sha512.New()}
+	values := make(map[string]interface{})
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key := root.Content[i].Value
+		if key == "sops" {
+			continue
+		}
+		value, err := d.walk(root.Content[i+1], []string{key})
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	lastModified, err := time.Parse(time.RFC3339, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lastmodified %q: %v", meta.LastModified, err)
+	}
+	mac, err := decryptValue(meta.MAC, dataKey, lastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt MAC: %v", err)
+	}
+	if mac != fmt.Sprintf("%X", d.hash.Sum(nil)) {
+		return nil, fmt.Errorf("MAC mismatch, the file was modified after it was encrypted")
+	}
+	return values, nil
+}
+
+// dataKey decrypts the data key of the file with the first matching age or PGP key.
+func (m metadata) dataKey(keys Keys) ([]byte, error) {
+	var errs []string
+	for _, recipient := range m.Age {
+		if len(keys.Age) == 0 {
+			break
+		}
+		r, err := age.Decrypt(agearmor.NewReader(strings.NewReader(recipient.Enc)), keys.Age...)
+		if err == nil {
+			return io.ReadAll(r)
+		}
+		errs = append(errs, fmt.Sprintf("age %s: %v", recipient.Recipient, err))
+	}
+
+	for _, key := range m.PGP {
+		if len(keys.PGP) == 0 {
+			break
+		}
+		block, err := pgparmor.Decode(strings.NewReader(key.Enc))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("pgp %s: %v", key.Fingerprint, err))
+			continue
+		}
+		message, err := openpgp.ReadMessage(block.Body, keys.PGP, nil, nil)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("pgp %s: %v", key.Fingerprint, err))
+			continue
+		}
+		return io.ReadAll(message.UnverifiedBody)
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no age or pgp key of the file matches the provided keys")
+	}
+	return nil, fmt.Errorf("failed to decrypt data key: %s", strings.Join(errs, "; "))
+}
+
+type decrypter struct {
+	meta metadata
+	key  []byte
+	hash hash.Hash
+}
+
+// walk decrypts a node and adds its values to the MAC in document order, as SOPS does.
+// Sequence items share the path of their sequence.
+func (d *decrypter) walk(node *yaml.Node, path []string) (interface{}, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return d.walk(node.Alias, path)
+	case yaml.MappingNode:
+		values := make(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			value, err := d.walk(node.Content[i+1], append(append([]string{}, path...), key))
+			if err != nil {
+				return nil, err
+			}
+			values[key] = value
+		}
+		return values, nil
+	case yaml.SequenceNode:
+		values := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := d.walk(item, path)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid value at %s: %v", strings.Join(path, "."), err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	if d.encrypted(path) {
+		ciphertext, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value at %s is not encrypted", strings.Join(path, "."))
+		}
+		var err error
+		value, err = decryptTyped(ciphertext, d.key, strings.Join(path, ":")+":")
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt value at %s: %v", strings.Join(path, "."), err)
+		}
+	}
+
+	d.hash.Write([]byte(macString(value)))
+	return value, nil
+}
+
+// encrypted applies the suffix and regex rules SOPS encrypted the file with.
+func (d *decrypter) encrypted(path []string) bool {
+	encrypted := true
+	if d.meta.UnencryptedSuffix != "" && anyKey(path, func(key string) bool { return strings.HasSuffix(key, d.meta.UnencryptedSuffix) }) {
+		encrypted = false
+	}
+	if d.meta.EncryptedSuffix != "" {
+		encrypted = anyKey(path, func(key string) bool { return strings.HasSuffix(key, d.meta.EncryptedSuffix) })
+	}
+	if d.meta.UnencryptedRegex != "" && anyKey(path, func(key string) bool { return matches(d.meta.UnencryptedRegex, key) }) {
+		encrypted = false
+	}
+	if d.meta.EncryptedRegex != "" {
+		encrypted = anyKey(path, func(key string) bool { return matches(d.meta.EncryptedRegex, key) })
+	}
+	return encrypted
+}
+
+func anyKey(path []string, match func(string) bool) bool {
+	for _, key := range path {
+		if match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(expr, value string) bool {
+	matched, _ := regexp.MatchString(expr, value)
+	return matched
+}
+
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// decryptTyped decrypts an ENC[AES256_GCM,...] value and converts it to its original type.
+func decryptTyped(value string, key []byte, additionalData string) (interface{}, error) {
+	if value == "" {
+		return "", nil
+	}
+	parts := encryptedValue.FindStringSubmatch(value)
+	if parts == nil {
+		return nil, fmt.Errorf("value is not in the sops format")
+	}
+
+	plaintext, err := decryptGCM(parts[1], parts[2], parts[3], key, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parts[4] {
+	case "str", "bytes":
+		return plaintext, nil
+	case "int":
+		return strconv.Atoi(plaintext)
+	case "float":
+		return strconv.ParseFloat(plaintext, 64)
+	case "bool":
+		return strconv.ParseBool(plaintext)
+	default:
+		return nil, fmt.Errorf("unknown type %s", parts[4])
+	}
+}
+
+// decryptValue decrypts an ENC[AES256_GCM,...] string value.
+func decryptValue(value string, key []byte, additionalData string) (string, error) {
+	plaintext, err := decryptTyped(value, key, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(plaintext), nil
+}
+
+func decryptGCM(data, iv, tag string, key []byte, additionalData string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid data: %v", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(iv)
+	if err != nil {
+		return "", fmt.Errorf("invalid iv: %v", err)
+	}
+	authTag, err := base64.StdEncoding.DecodeString(tag)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(nonce))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := gcm.Open(nil, nonce, append(ciphertext, authTag...), []byte(additionalData))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt with AES-GCM: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// macString formats a value the way SOPS hashes it into the MAC.
+func macString(value interface{}) string {
+	switch value := value.(type) {
+	case bool:
+		if value {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,145 @@
+package sops
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The fixtures in testdata were encrypted by the sops 3.9.0 CLI for the age key in
+// testdata/test.agekey, which exists only for these tests.
+func testKeys(t *testing.T) Keys {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "test.agekey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseKeys(map[string][]byte{"test.agekey": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecrypt(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    map[string]interface{}
+	}{
+		{
+			fixture: "secrets.enc.yaml",
+			want: map[string]interface{}{
+				"db_password": "s3cr3t",
+				"port":        5432,
+				"ratio":       0.5,
+				"enabled":     true,
+				"tags":        []interface{}{"a", "b"},
+				"nested":      map[string]interface{}{"token": "abc"},
+			},
+		},
+		{
+			fixture: "secrets.enc.json",
+			// The JSON store of sops encrypts every number as a float.
+			want: map[string]interface{}{
+				"db_password": "s3cr3t",
+				"port":        float64(5432),
+				"enabled":     false,
+				"nested":      map[string]interface{}{"token": "abc"},
+			},
+		},
+		{
+			fixture: "unencrypted-suffix.enc.yaml",
+			want: map[string]interface{}{
+				"db_password":        "s3cr3t",
+				"region_unencrypted": "eu-west-1",
+				"token":              "abc",
+			},
+		},
+		{
+			fixture: "encrypted-regex.enc.yaml",
+			want: map[string]interface{}{
+				"db_password": "s3cr3t",
+				"region":      "eu-west-1",
+			},
+		},
+	}
+
+	keys := testKeys(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			got, err := Decrypt(readFixture(t, tt.fixture), keys)
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decrypt() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsTamperedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		old     string
+		new     string
+	}{
+		{
+			name:    "modified unencrypted value",
+			fixture: "encrypted-regex.enc.yaml",
+			old:     "region: eu-west-1",
+			new:     "region: us-east-1",
+		},
+		{
+			name:    "modified unencrypted suffix value",
+			fixture: "unencrypted-suffix.enc.yaml",
+			old:     "region_unencrypted: eu-west-1",
+			new:     "region_unencrypted: us-east-1",
+		},
+	}
+
+	keys := testKeys(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := string(readFixture(t, tt.fixture))
+			if !strings.Contains(data, tt.old) {
+				t.Fatalf("fixture %s does not contain %q", tt.fixture, tt.old)
+			}
+			_, err := Decrypt([]byte(strings.Replace(data, tt.old, tt.new, 1)), keys)
+			if err == nil || !strings.Contains(err.Error(), "MAC mismatch") {
+				t.Errorf("Decrypt() error = %v, want MAC mismatch", err)
+			}
+		})
+	}
+}
+
+func TestDecryptWithoutMatchingKey(t *testing.T) {
+	// A valid age identity that none of the fixtures was encrypted for.
+	other := []byte("AGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX\n")
+	keys, err := ParseKeys(map[string][]byte{"other.agekey": other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decrypt(readFixture(t, "secrets.enc.yaml"), keys); err == nil {
+		t.Error("Decrypt() succeeded with a key the file was not encrypted for")
+	}
+}
+
+func TestDecryptUnencryptedDocument(t *testing.T) {
+	_, err := Decrypt([]byte("db_password: s3cr3t\n"), testKeys(t))
+	if err == nil || !strings.Contains(err.Error(), "not encrypted with sops") {
+		t.Errorf("Decrypt() error = %v, want not encrypted with sops", err)
+	}
+}
@@ -0,0 +1,92 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// backendSettingName matches the HCL identifiers a backend setting can be written as.
+var backendSettingName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// RenderBackendOverride renders a backend_override.tf file configuring the backend of the
+// Terraform configuration, replacing any backend block it already declares. Settings must
+// be named like HCL attributes and hold strings, bools or numbers.
+func RenderBackendOverride(backendType string, settings map[string]interface{}) (string, error) {
+	names := make([]string, 0, len(settings))
+	width := 0
+	for name := range settings {
+		if !backendSettingName.MatchString(name) {
+			return "", fmt.Errorf("invalid backend setting name %q", name)
+		}
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("terraform {\n")
+	fmt.Fprintf(&b, "  backend %s {\n", hclString(backendType))
+	for _, name := range names {
+		value, err := hclValue(settings[name])
+		if err != nil {
+			return "", fmt.Errorf("invalid backend setting %s: %v", name, err)
+		}
+		fmt.Fprintf(&b, "    %-*s = %s\n", width, name, value)
+	}
+	b.WriteString("  }\n}\n")
+	return b.String(), nil
+}
+
+// hclValue renders a string, bool or number as an HCL literal. Lists, maps and null are
+// rejected since backend settings are scalars.
+func hclValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return hclString(value), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32), nil
+	case int, int32, int64, uint, uint32, uint64:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("expected a string, bool or number, got %T", value)
+	}
+}
+
+// hclString renders a quoted HCL string. Template sequences are escaped since the rendered
+// files cannot contain expressions, and control characters use the \uNNNN escapes of HCL.
+func hclString(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i, r := range value {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		case (r == '$' || r == '%') && strings.HasPrefix(value[i+1:], "{"):
+			b.WriteRune(r)
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package terraform
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+// ReadRepoFiles reads files at a commit of a branch, or at its head if commit is empty, from an
+// in-memory clone, so their content is never written to disk. The clone is shallow unless the
+// branch moved past the commit.
+func ReadRepoFiles(repoURL, branch, commit, sshKey string, paths []string) (map[string][]byte, error) {
+	auth, err := sshAuth(sshKey)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := cloneBranch(repoURL, branch, auth, 1)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get head of %s: %v", repoURL, err)
+	}
+
+	hash := head.Hash()
+	if commit != "" && commit != hash.String() {
+		// The history of the branch is needed to find an earlier commit
+		repo, err = cloneBranch(repoURL, branch, auth, 0)
+		if err != nil {
+			return nil, err
+		}
+		hash = plumbing.NewHash(commit)
+	}
+	commitObject, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s of %s: %v", hash, repoURL, err)
+	}
+
+	files := make(map[string][]byte, len(paths))
+	for _, name := range paths {
+		file, err := commitObject.File(strings.TrimPrefix(path.Clean(name), "/"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find %s in %s: %v", name, repoURL, err)
+		}
+		reader, err := file.Reader()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		files[name] = content
+	}
+	return files, nil
+}
+
+// cloneBranch clones a single branch into memory, with the given depth or its full history if 0.
+func cloneBranch(repoURL, branch string, auth transport.AuthMethod, depth int) (*git.Repository, error) {
+	repo, err := git.Clone(memory.NewStorage(), nil, &git.CloneOptions{
+		URL:           repoURL,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		SingleBranch:  true,
+		Depth:         depth,
+		Auth:          auth,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone %s: %v", repoURL, err)
+	}
+	return repo, nil
+}
@@ -14,24 +14,12 @@ import (
 // It uses the SSH key for authentication if provided.
 func CloneOrPullRepo(repoURL, branch, repoDir, sshKey string) error {
 	var repo *git.Repository
-	var err error
-	var auth transport.AuthMethod
 
 	log.Printf("Starting CloneOrPullRepo for repo: %s, branch: %s, directory: %s", repoURL, branch, repoDir)
 
-	// If an SSH key is provided, set up the authentication
-	if sshKey != "" {
-		log.Println("Setting up SSH authentication")
-		signer, err := ssh.ParsePrivateKey([]byte(sshKey))
-		if err != nil {
-			log.Printf("Failed to parse SSH key: %v", err)
-			return err
-		}
-
-		auth = &gitssh.PublicKeys{
-			User:   "git",
-			Signer: signer,
-		}
+	auth, err := sshAuth(sshKey)
+	if err != nil {
+		return err
 	}
 
 	if _, err = os.Stat(repoDir); os.IsNotExist(err) {
@@ -77,3 +65,22 @@ func CloneOrPullRepo(repoURL, branch, repoDir, sshKey string) error {
 
 	return nil
 }
+
+// sshAuth returns the SSH authentication for the given private key, or nil if no key is provided.
+func sshAuth(sshKey string) (transport.AuthMethod, error) {
+	if sshKey == "" {
+		return nil, nil
+	}
+
+	log.Println("Setting up SSH authentication")
+	signer, err := ssh.ParsePrivateKey([]byte(sshKey))
+	if err != nil {
+		log.Printf("Failed to parse SSH key: %v", err)
+		return nil, err
+	}
+
+	return &gitssh.PublicKeys{
+		User:   "git",
+		Signer: signer,
+	}, nil
+}
@@ -0,0 +1,47 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// importAddressPattern matches the address of a managed resource instance, in modules or not,
+// with an optional literal index. Addresses are rendered as is, so nothing else is accepted.
+var importAddressPattern = regexp.MustCompile(`^(module\.[A-Za-z_][A-Za-z0-9_-]*(\[([0-9]+|"[^"\\]*")\])?\.)*[A-Za-z_][A-Za-z0-9_-]*\.[A-Za-z_][A-Za-z0-9_-]*(\[([0-9]+|"[^"\\]*")\])?$`)
+
+// Import imports an existing object into the state at a resource address.
+type Import struct {
+	To string
+	ID string
+}
+
+// ValidateImport rejects imports that cannot be rendered as an import block.
+func ValidateImport(imp Import) error {
+	if !importAddressPattern.MatchString(imp.To) || strings.HasPrefix(imp.To, "data.") {
+		return fmt.Errorf("invalid import address %q, expected a managed resource address", imp.To)
+	}
+	if imp.ID == "" {
+		return fmt.Errorf("import to %s requires an id", imp.To)
+	}
+	if strings.IndexFunc(imp.ID, unicode.IsControl) >= 0 {
+		return fmt.Errorf("import id of %s contains control characters", imp.To)
+	}
+	return nil
+}
+
+// RenderImports renders a file of import blocks, one per import.
+func RenderImports(imports []Import) string {
+	var b strings.Builder
+	for i, imp := range imports {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("import {\n")
+		fmt.Fprintf(&b, "  to = %s\n", imp.To)
+		fmt.Fprintf(&b, "  id = %s\n", hclString(imp.ID))
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Plan actions of a resource change.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionReplace = "replace"
+	ActionDelete  = "delete"
+)
+
+// PlanSummary counts the resource changes of a plan.
+type PlanSummary struct {
+	Create    int
+	Update    int
+	Replace   int
+	Delete    int
+	Resources []PlannedResource
+	// Imports are the existing objects the plan imports
+	Imports []PlannedImport
+	// Addresses are the addresses of every resource instance of the plan, whatever its action
+	Addresses []string
+	// OutputChanges reports changed outputs, which make a plan with no resource change still apply something
+	OutputChanges bool
+}
+
+// PlannedResource is a resource change of a plan.
+type PlannedResource struct {
+	Address string
+	Type    string
+	Action  string
+}
+
+// PlannedImport is an import of an existing object into the state.
+type PlannedImport struct {
+	Address string
+	ID      string
+}
+
+// Destructive reports whether the plan deletes or replaces resources.
+func (s PlanSummary) Destructive() bool {
+	return s.Delete > 0 || s.Replace > 0
+}
+
+// HasChanges reports whether applying the plan would change anything.
+func (s PlanSummary) HasChanges() bool {
+	return len(s.Resources) > 0 || len(s.Imports) > 0 || s.OutputChanges
+}
+
+type planJSON struct {
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Type    string `json:"type"`
+		Change  struct {
+			Actions   []string `json:"actions"`
+			Importing *struct {
+				ID string `json:"id"`
+			} `json:"importing"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+	OutputChanges map[string]struct {
+		Actions []string `json:"actions"`
+	} `json:"output_changes"`
+}
+
+// SummarizePlan summarizes the output of `terraform show -json` for a saved plan.
+// Reads and no-ops are left out of the resources, imports are listed whatever their action.
+func SummarizePlan(plan map[string]interface{}) (PlanSummary, error) {
+	var summary PlanSummary
+
+	raw, err := json.Marshal(plan)
+	if err != nil {
+		return summary, err
+	}
+	var parsed planJSON
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return summary, fmt.Errorf("invalid plan: %v", err)
+	}
+
+	for _, change := range parsed.ResourceChanges {
+		summary.Addresses = append(summary.Addresses, change.Address)
+		if change.Change.Importing != nil {
+			summary.Imports = append(summary.Imports, PlannedImport{Address: change.Address, ID: change.Change.Importing.ID})
+		}
+
+		action := planAction(change.Change.Actions)
+		switch action {
+		case ActionCreate:
+			summary.Create++
+		case ActionUpdate:
+			summary.Update++
+		case ActionReplace:
+			summary.Replace++
+		case ActionDelete:
+			summary.Delete++
+		default:
+			continue
+		}
+		summary.Resources = append(summary.Resources, PlannedResource{Address: change.Address, Type: change.Type, Action: action})
+	}
+
+	for _, change := range parsed.OutputChanges {
+		if action := planAction(change.Actions); action != "" {
+			summary.OutputChanges = true
+		}
+	}
+	return summary, nil
+}
+
+// planAction maps the actions of a change to a single action, empty for reads and no-ops.
+func planAction(actions []string) string {
+	switch {
+	case len(actions) == 2:
+		// ["delete", "create"] or ["create", "delete"]
+		return ActionReplace
+	case len(actions) == 1 && actions[0] == "create":
+		return ActionCreate
+	case len(actions) == 1 && actions[0] == "update":
+		return ActionUpdate
+	case len(actions) == 1 && actions[0] == "delete":
+		return ActionDelete
+	default:
+		return ""
+	}
+}
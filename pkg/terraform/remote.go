@@ -0,0 +1,36 @@
+package terraform
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// ListRemoteBranches queries the ref advertisement of the remote, like git ls-remote,
+// and returns the commit hash of every branch without cloning the repository.
+func ListRemoteBranches(repoURL, sshKey string) (map[string]string, error) {
+	auth, err := sshAuth(sshKey)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{repoURL},
+	})
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs of %s: %v", repoURL, err)
+	}
+
+	branches := make(map[string]string)
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			branches[ref.Name().Short()] = ref.Hash().String()
+		}
+	}
+	return branches, nil
+}
@@ -0,0 +1,14 @@
+package util
+
+import (
+	"os"
+)
+
+// GetControllerNamespace returns the namespace the controller runs in, which holds its cluster-wide configuration.
+func GetControllerNamespace() string {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultControllerNamespace
+	}
+	return namespace
+}
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"os"
+)
+
+const (
+	defaultControllerNamespace = "alustan"
+	defaultFreezeConfigMap     = "terraform-controller-freeze"
+)
+
+// GetFreezeConfigMap returns the namespace and name of the ConfigMap listing cluster-wide change freezes.
+func GetFreezeConfigMap() (string, string) {
+	namespace := GetControllerNamespace()
+
+	name := os.Getenv("FREEZE_CONFIGMAP")
+	if name == "" {
+		name = defaultFreezeConfigMap
+	}
+
+	return namespace, name
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+const defaultPollInterval = 5 * time.Minute // Default remote polling interval
+
+// GetPollInterval retrieves how often remote repositories are polled for new commits
+// from the environment variable or returns the default value.
+func GetPollInterval() time.Duration {
+	pollIntervalStr := os.Getenv("POLL_INTERVAL")
+	if pollIntervalStr == "" {
+		log.Printf("POLL_INTERVAL not set, using default value: %v", defaultPollInterval)
+		return defaultPollInterval
+	}
+
+	pollInterval, err := time.ParseDuration(pollIntervalStr)
+	if err != nil {
+		log.Printf("Invalid POLL_INTERVAL format, using default value: %v. Error: %v", defaultPollInterval, err)
+		return defaultPollInterval
+	}
+
+	log.Printf("Using POLL_INTERVAL from environment: %v", pollInterval)
+	return pollInterval
+}
@@ -0,0 +1,16 @@
+package util
+
+import (
+	"os"
+)
+
+const defaultPricingConfigMap = "terraform-controller-pricing"
+
+// GetPricingConfigMap returns the namespace and name of the ConfigMap holding the pricing catalog used to estimate plan costs.
+func GetPricingConfigMap() (string, string) {
+	name := os.Getenv("PRICING_CONFIGMAP")
+	if name == "" {
+		name = defaultPricingConfigMap
+	}
+	return GetControllerNamespace(), name
+}
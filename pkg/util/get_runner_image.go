@@ -0,0 +1,16 @@
+package util
+
+import (
+	"os"
+)
+
+const defaultRunnerImage = "docker.io/alustan/terraform-runner:0.1.0"
+
+// GetRunnerImage returns the image the builtin runner binary is copied from into run images.
+func GetRunnerImage() string {
+	image := os.Getenv("RUNNER_IMAGE")
+	if image == "" {
+		image = defaultRunnerImage
+	}
+	return image
+}
@@ -0,0 +1,27 @@
+package util
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// NextScheduleTime returns the first activation of the standard cron expression after the given time,
+// evaluated in timeZone (UTC when empty).
+func NextScheduleTime(expression, timeZone string, after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cron expression %q: %v", expression, err)
+	}
+
+	location := time.UTC
+	if timeZone != "" {
+		location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone %q: %v", timeZone, err)
+		}
+	}
+
+	return schedule.Next(after.In(location)), nil
+}
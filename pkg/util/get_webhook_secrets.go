@@ -0,0 +1,28 @@
+package util
+
+import (
+	"log"
+	"os"
+)
+
+// webhookSecretEnvVars maps each supported git provider to the environment variable holding its webhook secret.
+var webhookSecretEnvVars = map[string]string{
+	"github":    "GITHUB_WEBHOOK_SECRET",
+	"gitlab":    "GITLAB_WEBHOOK_SECRET",
+	"bitbucket": "BITBUCKET_WEBHOOK_SECRET",
+}
+
+// GetWebhookSecrets retrieves the git push webhook secrets from the environment, keyed by provider.
+// Providers without a secret are omitted so their webhook endpoint rejects all requests.
+func GetWebhookSecrets() map[string]string {
+	secrets := make(map[string]string)
+	for provider, envVar := range webhookSecretEnvVars {
+		secret := os.Getenv(envVar)
+		if secret == "" {
+			log.Printf("%s not set, %s webhooks are disabled", envVar, provider)
+			continue
+		}
+		secrets[provider] = secret
+	}
+	return secrets
+}
@@ -53,7 +53,12 @@ func (p *AWSPlugin) Execute() (map[string]interface{}, error) {
 	if (err != nil) {
 		return nil, err
 	}
-	result, err := json.Marshal(resources)
+	// Group the resources by service so they can be returned as a map
+	byService := make(map[string][]map[string]interface{})
+	for _, resource := range resources {
+		byService[resource.Service] = append(byService[resource.Service], resource.Resource)
+	}
+	result, err := json.Marshal(byService)
 	if (err != nil) {
 		return nil, fmt.Errorf("error marshalling creds: %v", err)
 	}